@@ -1,28 +1,38 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strings"
 
 	"github.com/u8717/crypt/libcipher"
 )
 
 func main() {
 	// CLI Flags.
-	keyFile := flag.String("key", "", "Path to the key file (required)")
+	keyFile := flag.String("key", "", "Path to the typed key file of keygen (required unless -kdf password or x25519), legacy raw keys are converted with keygen -import")
+	kdf := flag.String("kdf", "hkdf", "Key derivation: 'hkdf' derives independent keys from the whole key file, 'password' derives them from a passphrase (CBCCRYPT_PASSPHRASE or stdin), 'x25519' encrypts to -recipient and decrypts with the identity in the key file, 'none' splits the first 32 bytes to decrypt data of earlier versions only")
+	recipient := flag.String("recipient", "", "Recipient (x25519:...) to encrypt to with -kdf x25519")
+	alg := flag.String("alg", "", "Algorithm with -kdf hkdf, one of: "+strings.Join(libcipher.Algorithms(), ", ")+". Empty keeps AES-CBC-HMAC-SHA256 (existing data)")
+	armor := flag.Bool("armor", false, "Print encrypted packages ASCII-armored instead of bare base64, armored input is always detected")
 	flag.Parse()
 
-	if len(*keyFile) == 0 {
+	if len(*keyFile) == 0 && *kdf != "password" && *kdf != "x25519" {
 		fmt.Fprintln(os.Stderr, "Error: key file was not provided")
 		os.Exit(1)
 	}
 
 	// Check for both mode and input.
 	if flag.NArg() < 2 {
-		fmt.Fprintln(os.Stderr, "Error: mode (e/d) and input text are required as command-line arguments.")
+		fmt.Fprintln(os.Stderr, "Error: mode (e/d) and input text ('-' reads stdin) are required as command-line arguments.")
 		os.Exit(1)
 	}
 
@@ -32,41 +42,248 @@ func main() {
 		os.Exit(1)
 	}
 
-	input := []byte(flag.Arg(1))
+	input := readInput(flag.Arg(1))
+
+	if len(*alg) != 0 && *kdf != "hkdf" {
+		fmt.Fprintln(os.Stderr, "Error: an algorithm can only be selected with -kdf hkdf")
+		os.Exit(1)
+	}
+	if *kdf == "none" && mode == "e" {
+		fmt.Fprintln(os.Stderr, "Error: -kdf none only decrypts data of earlier versions, encrypt with -kdf hkdf")
+		os.Exit(1)
+	}
 
 	// Key Loading.
-	encryptionKey, integrityKey := loadBasicKey(keyFile)
+	var encryptor libcipher.Encryptor
+	var decryptor libcipher.Decryptor
+	var err error
+	if key := loadKeyFile(keyFile); key != nil && key.Algorithm != libcipher.KeyAlgorithmMaster && key.Algorithm != libcipher.KeyAlgorithmX25519 {
+		// Keys of a registered algorithm are used as they are, nothing is derived.
+		if isFlagSet("kdf") {
+			fmt.Fprintf(os.Stderr, "Error: the key file holds a key for %s, it can't be used with -kdf\n", key.Algorithm)
+			os.Exit(1)
+		}
+		if mode == "e" {
+			encryptor, err = key.NewEncryptor()
+		} else {
+			decryptor, err = key.NewDecryptor()
+		}
+	} else {
+		switch *kdf {
+		case "password":
+			passphrase := readPassphrase()
+			if mode == "e" {
+				encryptor, err = libcipher.NewPasswordEncryptor(passphrase, libcipher.DefaultPasswordParams(libcipher.KDFScrypt), libcipher.AlgorithmCBCHMAC)
+			} else {
+				decryptor, err = libcipher.NewPasswordDecryptor(passphrase)
+			}
+		case "x25519":
+			if mode == "e" {
+				var publicKey *ecdh.PublicKey
+				publicKey, err = libcipher.ParseRecipient(*recipient)
+				if err == nil {
+					encryptor, err = libcipher.NewX25519Encryptor(publicKey, rand.Reader)
+				}
+			} else {
+				decryptor, err = libcipher.NewX25519Decryptor(loadIdentity(keyFile))
+			}
+		case "hkdf":
+			if len(*alg) != 0 {
+				keys := loadAlgorithmKeys(keyFile, *alg)
+				if mode == "e" {
+					encryptor, err = libcipher.NewEncryptor(*alg, keys...)
+				} else {
+					decryptor, err = libcipher.NewDecryptor(*alg, keys...)
+				}
+				break
+			}
+			fallthrough
+		case "none":
+			var encryptionKey, integrityKey []byte
+			if *kdf == "hkdf" {
+				encryptionKey, integrityKey = loadDerivedKey(keyFile)
+			} else {
+				encryptionKey, integrityKey = loadBasicKey(keyFile)
+			}
+			if mode == "e" {
+				encryptor, err = libcipher.NewCBCHMACEncryptor(encryptionKey, integrityKey, sha256.New)
+			} else {
+				decryptor, err = libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+			}
+		default:
+			fmt.Fprintln(os.Stderr, "Error: invalid kdf. Please use 'hkdf', 'password', 'x25519' or 'none'.")
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error initializing cryptor:", err)
+		os.Exit(1)
+	}
 
 	// Crypt Operation.
 	var output string
 	if mode == "e" {
-		output = encrypt(encryptionKey, integrityKey, input)
+		output = encrypt(encryptor, input, *armor)
 	} else {
-		output = decrypt(encryptionKey, integrityKey, input)
+		output = decrypt(decryptor, input)
 	}
 
 	fmt.Println(output)
 }
 
-func loadBasicKey(keyFile *string) ([]byte, []byte) {
-	key, err := os.ReadFile(*keyFile)
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadKeyFile returns the typed key file at keyFile, nil for a legacy raw key file or without a key file.
+func loadKeyFile(keyFile *string) *libcipher.KeyFile {
+	if len(*keyFile) == 0 {
+		return nil
+	}
+	content, err := os.ReadFile(*keyFile)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error reading key file:", err)
 		os.Exit(1)
 	}
+	defer clear(content)
+	if !libcipher.IsKeyFile(content) {
+		return nil
+	}
+	key, err := libcipher.ParseKeyFile(content)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing key file:", err)
+		os.Exit(1)
+	}
+	return key
+}
+
+// loadMasterKey returns the material of a typed master key file.
+// Legacy raw key files are refused, keygen -import converts them without changing their bytes.
+func loadMasterKey(keyFile *string) []byte {
+	key := loadKeyFile(keyFile)
+	if key == nil {
+		fmt.Fprintln(os.Stderr, "Error: the key file is not a typed key file, convert a legacy raw key with keygen -import <file> -out <key file>")
+		os.Exit(1)
+	}
+	master, err := key.MasterKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading key:", err)
+		os.Exit(1)
+	}
+	return master
+}
+
+func loadBasicKey(keyFile *string) ([]byte, []byte) {
+	key := loadMasterKey(keyFile)
+	if len(key) < 32 {
+		fmt.Fprintln(os.Stderr, "Error: key file must hold at least 32 bytes")
+		os.Exit(1)
+	}
 	// Split the key into encryption and integrity keys.
 	encryptionKey := key[:16]
 	integrityKey := key[16:32]
 	return encryptionKey, integrityKey
 }
 
-func decrypt(encryptionKey []byte, integrityKey []byte, input []byte) string {
-	decryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+func loadDerivedKey(keyFile *string) ([]byte, []byte) {
+	// Derive the encryption and integrity keys from the whole key.
+	encryptionKey, integrityKey, err := libcipher.DeriveCBCHMACKeys(loadMasterKey(keyFile), nil, "cmd/cbccrypt", sha256.New)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error deriving keys:", err)
+		os.Exit(1)
+	}
+	return encryptionKey, integrityKey
+}
+
+func loadAlgorithmKeys(keyFile *string, alg string) [][]byte {
+	// Derive all keys of the algorithm from the whole key.
+	keys, err := libcipher.DeriveAlgorithmKeys(alg, loadMasterKey(keyFile), nil, "cmd/cbccrypt")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error deriving keys:", err)
+		os.Exit(1)
+	}
+	return keys
+}
+
+// loadIdentity reads the identity of a typed x25519 key file or the first X25519 identity of a legacy key file.
+// Comment lines starting with '#' are skipped.
+func loadIdentity(keyFile *string) *ecdh.PrivateKey {
+	if len(*keyFile) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: key file with the identity was not provided")
+		os.Exit(1)
+	}
+	if key := loadKeyFile(keyFile); key != nil {
+		identity, err := key.Identity()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading identity:", err)
+			os.Exit(1)
+		}
+		return identity
+	}
+	content, err := os.ReadFile(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading key file:", err)
+		os.Exit(1)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, err := libcipher.ParseIdentity(line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error parsing identity:", err)
+			os.Exit(1)
+		}
+		return identity
+	}
+	fmt.Fprintln(os.Stderr, "Error: key file contains no identity")
+	os.Exit(1)
+	return nil
+}
+
+// readPassphrase reads the passphrase from CBCCRYPT_PASSPHRASE or the first line of stdin.
+func readPassphrase() []byte {
+	if passphrase, ok := os.LookupEnv("CBCCRYPT_PASSPHRASE"); ok {
+		return []byte(passphrase)
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		fmt.Fprintln(os.Stderr, "Error reading passphrase:", err)
+		os.Exit(1)
+	}
+	return []byte(strings.TrimRight(line, "\r\n"))
+}
+
+// readInput returns the input argument, '-' reads the whole stdin.
+func readInput(arg string) []byte {
+	if arg != "-" {
+		return []byte(arg)
+	}
+	input, err := io.ReadAll(os.Stdin)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error initializing decryptor:", err)
+		fmt.Fprintln(os.Stderr, "Error reading stdin:", err)
 		os.Exit(1)
 	}
-	decodetInput, err := base64.StdEncoding.DecodeString(string(input))
+	return input
+}
+
+func decrypt(decryptor libcipher.Decryptor, input []byte) string {
+	var decodetInput []byte
+	var err error
+	if libcipher.IsArmored(input) {
+		decodetInput, err = libcipher.DecodeArmor(input)
+	} else {
+		decodetInput, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(input)))
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error decoding input to byte array:", err)
 		os.Exit(1)
@@ -81,17 +298,15 @@ func decrypt(encryptionKey []byte, integrityKey []byte, input []byte) string {
 	return string(output)
 }
 
-func encrypt(encryptionKey []byte, integrityKey []byte, input []byte) string {
-	encryptor, err := libcipher.NewCBCHMACEncryptor(encryptionKey, integrityKey, sha256.New)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error initializing encryptor:", err)
-		os.Exit(1)
-	}
+func encrypt(encryptor libcipher.Encryptor, input []byte, armor bool) string {
 	output, err := encryptor.Crypt(input, nil)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error encrypting file:", err)
 		os.Exit(1)
 	}
 
+	if armor {
+		return strings.TrimSuffix(string(libcipher.EncodeArmor(output)), "\n")
+	}
 	return base64.StdEncoding.EncodeToString(output)
 }
@@ -1,19 +1,27 @@
 package main
 
 import (
+	"bufio"
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"os"
 	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/u8717/crypt/libcipher"
 	"github.com/u8717/crypt/libstore"
 )
 
 var (
 	location string
 	token    string
+	keyFile  string
+	kdf      string
+	alg      string
 	page     int
 	pageSize int
 	sortKeys bool
@@ -45,6 +53,18 @@ var (
 		Run:   getCommandFunc,
 	}
 
+	exportCmd = &cobra.Command{
+		Use:   "export <id>",
+		Short: "Print the last sealed entry of an item ASCII-armored",
+		Run:   exportCommandFunc,
+	}
+
+	importCmd = &cobra.Command{
+		Use:   "import <id> [file]",
+		Short: "Append an ASCII-armored sealed entry to an item, reads stdin without file",
+		Run:   importCommandFunc,
+	}
+
 	listCmd = &cobra.Command{
 		Use:   "list",
 		Short: "List all registered keys",
@@ -60,25 +80,94 @@ var (
 	}
 )
 
+// Initialize the file operations below the store
+func getOps() libstore.Ops {
+	ops, err := libstore.NewFileOps(".")
+	if err != nil {
+		log.Fatalf("Failed to initialize file operations: %v", err)
+	}
+	return ops
+}
+
 // Initialize the store
 func getStore() libstore.Ops {
-	if len(token) < 64 {
-		log.Fatalf("Master token must be at least 64 bytes long.")
+	ops := getOps()
+	var err error
+
+	if alg != "" && kdf != "hkdf" {
+		log.Fatalf("An algorithm can only be selected with --kdf hkdf.")
 	}
-	encryptionToken := token[:32]
-	integrityToken := token[32:]
 
-	ops, err := libstore.NewFileOps(".")
-	if err != nil {
-		log.Fatalf("Failed to initialize file operations: %v", err)
+	if keyFile != "" {
+		if token != "" || kdf == "password" {
+			log.Fatalf("Use either --key, --key-file or --kdf password.")
+		}
+		key, err := libcipher.LoadKeyFile(keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load key file: %v", err)
+		}
+		if key.Algorithm != libcipher.KeyAlgorithmMaster {
+			// Keys of a registered algorithm are used as they are, nothing is derived.
+			if rootCmd.PersistentFlags().Changed("kdf") {
+				log.Fatalf("The key file holds a key for %s, it can't be used with --kdf.", key.Algorithm)
+			}
+			keys, err := key.Keys()
+			if err != nil {
+				log.Fatalf("Failed to load key file: %v", err)
+			}
+			manager, err := libstore.NewManagerWithAlgorithm(ops, key.Algorithm, keys...)
+			if err != nil {
+				log.Fatalf("Failed to initialize cryptographic manager: %v", err)
+			}
+			return manager
+		}
+		// The master key replaces the token.
+		token = string(key.Material)
+	}
+
+	var manager libstore.Ops
+	switch kdf {
+	case "hkdf":
+		if alg == "" {
+			manager, err = libstore.NewManagerFromMasterKey(ops, []byte(token), sha256.New)
+			break
+		}
+		var keys [][]byte
+		keys, err = libcipher.DeriveAlgorithmKeys(alg, []byte(token), nil, "cmd/files")
+		if err == nil {
+			manager, err = libstore.NewManagerWithAlgorithm(ops, alg, keys...)
+		}
+	case "password":
+		manager, err = libstore.NewManagerFromPassword(ops, readPassphrase(), libcipher.DefaultPasswordParams(libcipher.KDFScrypt))
+	case "none":
+		if len(token) < 64 {
+			log.Fatalf("Master token must be at least 64 bytes long.")
+		}
+		encryptionToken := token[:32]
+		integrityToken := token[32:]
+		manager, err = libstore.NewManager(ops, []byte(encryptionToken), []byte(integrityToken), sha256.New)
+	default:
+		log.Fatalf("Unknown key derivation %q, use 'hkdf', 'password' or 'none'.", kdf)
 	}
-	manager, err := libstore.NewManager(ops, []byte(encryptionToken), []byte(integrityToken), sha256.New)
 	if err != nil {
 		log.Fatalf("Failed to initialize cryptographic manager: %v", err)
 	}
 	return manager
 }
 
+// readPassphrase reads the passphrase from FILES_PASSPHRASE or the first line of stdin.
+func readPassphrase() []byte {
+	if passphrase, ok := os.LookupEnv("FILES_PASSPHRASE"); ok {
+		return []byte(passphrase)
+	}
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && len(line) == 0 {
+		log.Fatalf("Failed to read passphrase: %v", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n"))
+}
+
 // Create command function
 func createCommandFunc(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
@@ -168,6 +257,68 @@ func getCommandFunc(cmd *cobra.Command, args []string) {
 	fmt.Printf("%s\n", rec)
 }
 
+// Export command function, the entry stays sealed and no key is needed
+func exportCommandFunc(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		slog.Error("Insufficient arguments for export command.")
+		err := cmd.Help()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		return
+	}
+
+	id := args[0]
+	slog.Debug("Exporting item", "id", id)
+
+	vault, err := getOps().ReadLast(id)
+	if err != nil {
+		slog.Error("Failed to export item", "id", id, "error", err)
+		return
+	}
+	fmt.Printf("%s", libcipher.EncodeArmor(vault))
+}
+
+// Import command function, the entry is only readable with the key of the exporting store
+func importCommandFunc(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		slog.Error("Insufficient arguments for import command.")
+		err := cmd.Help()
+		if err != nil {
+			log.Fatalf(err.Error())
+		}
+		return
+	}
+
+	id := args[0]
+	slog.Debug("Importing item", "id", id)
+
+	var armored []byte
+	var err error
+	if len(args) > 1 {
+		armored, err = os.ReadFile(args[1])
+	} else {
+		armored, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		slog.Error("Failed to read armored entry", "id", id, "error", err)
+		return
+	}
+	vault, err := libcipher.DecodeArmor(armored)
+	if err != nil {
+		slog.Error("Failed to decode armored entry", "id", id, "error", err)
+		return
+	}
+
+	ops := getOps()
+	if err := ops.Create(id); err != nil {
+		slog.Debug("Appending to existing item", "id", id, "error", err)
+	}
+	if err := ops.AppendTo(id, vault); err != nil {
+		slog.Error("Failed to import item", "id", id, "error", err)
+	}
+}
+
 // List command function
 func listCommandFunc(cmd *cobra.Command, args []string) {
 	slog.Debug("Listing keys")
@@ -200,12 +351,30 @@ func init() {
 		"key used for both encrypting/decrypting and signing/verifying data.",
 	)
 
+	rootCmd.PersistentFlags().StringVar(
+		&keyFile,
+		"key-file", "",
+		"typed key file written by keygen -out, a master key replaces --key, the key of a registered algorithm is used as it is.",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&kdf,
+		"kdf", "hkdf",
+		"key derivation: 'hkdf' derives independent keys from the key, 'password' derives them from a passphrase (FILES_PASSPHRASE or stdin), 'none' splits the key in half, for stores of earlier versions only.",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&alg,
+		"alg", "",
+		"algorithm of the store with --kdf hkdf, one of: "+strings.Join(libcipher.Algorithms(), ", ")+". Empty keeps AES-CBC-HMAC-SHA256 (existing stores).",
+	)
+
 	rootCmd.PersistentFlags().StringVarP(
 		&location,
 		"location", "l", "",
 		"Root folder for the filesystem.",
 	)
-	rootCmd.AddCommand(createCmd, deleteCmd, updateCmd, getCmd, listCmd)
+	rootCmd.AddCommand(createCmd, deleteCmd, updateCmd, getCmd, exportCmd, importCmd, listCmd)
 }
 
 func main() {
@@ -1,19 +1,243 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/u8717/crypt/libcipher"
+	"github.com/u8717/crypt/libcipher/shamir"
 )
 
 func main() {
-	// Generate a key using the keygen package
-	encodedKey, err := libcipher.GenerateKey(64)
-	if err != nil {
+	// CLI Flags.
+	keyType := flag.String("type", "symmetric", "Key type: 'symmetric' writes a key file of -alg, 'x25519' writes an identity and prints its recipient")
+	out := flag.String("out", "", "Path of the typed key file, readable by the owner only, '<alg>.key' or 'x25519.key' by default. Existing files are never overwritten")
+	alg := flag.String("alg", libcipher.KeyAlgorithmMaster, "Algorithm of a symmetric key file, 'master' or one of: "+strings.Join(libcipher.Algorithms(), ", "))
+	keyID := flag.Uint("key-id", 0, "Key ID of the key file, stamped into the packages of registered algorithms")
+	purpose := flag.String("purpose", "", "Purpose of the key file, e.g. 'files store prod'")
+	shares := flag.Int("shares", 0, "Split a typed key file into this many shares instead of writing it, requires -threshold")
+	threshold := flag.Int("threshold", 0, "Number of shares required to recombine the key with -shares")
+	combine := flag.Bool("combine", false, "Recombine a key from the shares in the files given as arguments, or stdin without arguments, a key file is written to -out")
+	importKey := flag.String("import", "", "Wrap the legacy raw key in this file into a master key file, its bytes are kept as they are so -kdf none still decrypts data of earlier versions")
+	printLegacy := flag.Bool("print", false, "Print a legacy hex key or an identity with its recipient instead of writing a key file")
+	flag.Parse()
+
+	if *combine {
+		combineShares(flag.Args(), *out)
+		return
+	}
+	if *printLegacy {
+		if isFlagSet("out") || isFlagSet("alg") || isFlagSet("key-id") || isFlagSet("purpose") || isFlagSet("shares") || isFlagSet("threshold") || isFlagSet("import") {
+			fmt.Println("Error: -print only takes -type, the key is not written to a key file.")
+			os.Exit(1)
+		}
+		printKey(*keyType)
+		return
+	}
+	if (*shares != 0 || *threshold != 0) && len(*out) != 0 {
+		fmt.Println("Error: a key split into shares must not be written to a file.")
+		os.Exit(1)
+	}
+	if *keyID > math.MaxUint32 {
+		fmt.Println("Error: key ID must fit into 32 bits.")
+		os.Exit(1)
+	}
+
+	algorithm := *alg
+	if *keyType == "x25519" {
+		algorithm = libcipher.KeyAlgorithmX25519
+	} else if *keyType != "symmetric" {
+		fmt.Println("Error: invalid key type. Please use 'symmetric' or 'x25519'.")
+		os.Exit(1)
+	}
+	var key *libcipher.KeyFile
+	var err error
+	if len(*importKey) != 0 {
+		if algorithm != libcipher.KeyAlgorithmMaster {
+			fmt.Println("Error: a legacy key can only be imported as a master key.")
+			os.Exit(1)
+		}
+		if key, err = importLegacyKey(*importKey, uint32(*keyID), *purpose); err != nil {
+			fmt.Println("Error importing key:", err)
+			os.Exit(1)
+		}
+	} else if key, err = libcipher.GenerateKeyFile(algorithm, uint32(*keyID), *purpose, rand.Reader); err != nil {
 		fmt.Println("Error generating key:", err)
 		os.Exit(1)
 	}
+	defer clear(key.Material)
+
+	if *shares != 0 || *threshold != 0 {
+		splitKey(key, *threshold, *shares)
+	} else {
+		path := *out
+		if len(path) == 0 {
+			path = algorithm + ".key"
+		}
+		if err := key.Save(path); err != nil {
+			fmt.Println("Error writing key file:", err)
+			os.Exit(1)
+		}
+		fmt.Println("# key file:", path)
+	}
+	if identity, err := key.Identity(); err == nil {
+		// The recipient is public, share it with everyone who has to encrypt for this identity.
+		fmt.Println("# recipient:", libcipher.FormatRecipient(identity.PublicKey()))
+	}
+}
+
+// isFlagSet reports whether the flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// printKey prints a random 64 byte hex key or an identity with its recipient, as keygen did before key files.
+func printKey(keyType string) {
+	switch keyType {
+	case "symmetric":
+		// Generate a key using the keygen package
+		encodedKey, err := libcipher.GenerateKey(64)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			os.Exit(1)
+		}
+
+		fmt.Println(encodedKey)
+	case "x25519":
+		identity, err := libcipher.GenerateX25519Identity(rand.Reader)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			os.Exit(1)
+		}
+
+		// The recipient is public, share it with everyone who has to encrypt for this identity.
+		fmt.Println("# recipient:", libcipher.FormatRecipient(identity.PublicKey()))
+		fmt.Println(libcipher.FormatIdentity(identity))
+	default:
+		fmt.Println("Error: invalid key type. Please use 'symmetric' or 'x25519'.")
+		os.Exit(1)
+	}
+}
 
-	fmt.Println(encodedKey)
+// importLegacyKey wraps the raw key in the file into a master key file.
+// Only surrounding whitespace is stripped, e.g. the newline after a hex key, the bytes are not decoded.
+func importLegacyKey(file string, keyID uint32, purpose string) (*libcipher.KeyFile, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(content)
+	if libcipher.IsKeyFile(content) {
+		return nil, libcipher.KeyFileError(fmt.Sprintf("%s is already a key file", file))
+	}
+
+	return &libcipher.KeyFile{
+		Algorithm: libcipher.KeyAlgorithmMaster,
+		KeyID:     keyID,
+		Created:   time.Now().UTC().Truncate(time.Second),
+		Purpose:   purpose,
+		Material:  bytes.Clone(bytes.TrimSpace(content)),
+	}, nil
+}
+
+// splitKey prints the shares of the encoded key file, one per line, the key itself is never printed.
+func splitKey(key *libcipher.KeyFile, threshold int, n int) {
+	encoded, err := key.MarshalText()
+	if err != nil {
+		fmt.Println("Error encoding key:", err)
+		os.Exit(1)
+	}
+	defer clear(encoded)
+	keyShares, err := shamir.Split(encoded, threshold, n, rand.Reader)
+	if err != nil {
+		fmt.Println("Error splitting key:", err)
+		os.Exit(1)
+	}
+
+	// Hand every share to a different holder, any threshold of them recombine the key with -combine.
+	for _, share := range keyShares {
+		fmt.Printf("# share %d of %d, %d required\n", share.Index, n, threshold)
+		fmt.Println(shamir.Format(share))
+	}
+}
+
+// combineShares recombines the key from the shares in the files, or stdin without files.
+// A key file is written to out, or printed without out. Keys split before key files existed are printed hex encoded.
+// Empty lines and comment lines starting with '#' are skipped.
+func combineShares(files []string, out string) {
+	var readers []io.Reader
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			fmt.Println("Error reading shares:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if len(readers) == 0 {
+		readers = append(readers, os.Stdin)
+	}
+
+	var keyShares []shamir.Share
+	for _, reader := range readers {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if len(line) == 0 || strings.HasPrefix(line, "#") {
+				continue
+			}
+			share, err := shamir.Parse(line)
+			if err != nil {
+				fmt.Println("Error parsing share:", err)
+				os.Exit(1)
+			}
+			keyShares = append(keyShares, share)
+		}
+		if err := scanner.Err(); err != nil {
+			fmt.Println("Error reading shares:", err)
+			os.Exit(1)
+		}
+	}
+
+	secret, err := shamir.Combine(keyShares)
+	if err != nil {
+		fmt.Println("Error combining shares:", err)
+		os.Exit(1)
+	}
+	defer clear(secret)
+	if !libcipher.IsKeyFile(secret) {
+		fmt.Println(hex.EncodeToString(secret))
+		return
+	}
+
+	key, err := libcipher.ParseKeyFile(secret)
+	if err != nil {
+		fmt.Println("Error parsing recombined key file:", err)
+		os.Exit(1)
+	}
+	defer clear(key.Material)
+	if len(out) == 0 {
+		fmt.Printf("%s", secret)
+		return
+	}
+	if err := key.Save(out); err != nil {
+		fmt.Println("Error writing key file:", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,121 @@
+package libcipher
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+)
+
+// aeadCBCHMAC adapts the AES-CBC+HMAC composite to the cipher.AEAD interface.
+type aeadCBCHMAC cryptorCBCHMAC
+
+// NewCBCHMACAEAD creates a cipher.AEAD of the AES-CBC+HMAC composite, e.g. for libraries only accepting cipher.AEAD.
+// The key requirements are the same as for NewCBCHMACEncryptor.
+//
+//	The sealed format:
+//	[ Block 1 | Block 2 | ... | MAC ]
+//
+// The nonce is the CBC initialization vector and is not part of the output, NonceSize is the AES block size.
+// Unlike for GCM a counter is not sufficient, every nonce has to be unpredictable, e.g. read from rand.Reader.
+// Overhead is the MAC size plus one block, the upper bound of the PKCS7 padding, the output may be shorter.
+//
+// Following RFC 7518 the MAC is calculated from ( AD | Initialization Vector | Block 1 | Block 2 | ... | AD bit length (8 bytes) ).
+// There is no header, the packages of NewCBCHMACEncryptor & this AEAD are not interchangeable.
+func NewCBCHMACAEAD(encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash) (cipher.AEAD, error) {
+	cry, err := newCBCHMACryptor(encyptionKey, integrityKey, calculateMAC)
+	if err != nil {
+		return nil, err
+	}
+
+	return (aeadCBCHMAC)(cry), nil
+}
+
+// NonceSize returns the size of the initialization vector.
+func (a aeadCBCHMAC) NonceSize() int {
+	return a.pher.BlockSize()
+}
+
+// Overhead returns the maximum difference between the lengths of a plaintext and its ciphertext.
+func (a aeadCBCHMAC) Overhead() int {
+	return a.macLenght + a.pher.BlockSize()
+}
+
+// Seal encrypts and authenticates plaintext & additionalData and appends the result to dst.
+// To reuse plaintext's storage for the output, use plaintext[:0] as dst, otherwise dst must not overlap plaintext.
+func (a aeadCBCHMAC) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != a.NonceSize() {
+		panic("libcipher/cipher: incorrect nonce length given to AES-CBC-HMAC")
+	}
+	blockSize := a.pher.BlockSize()
+	padding := blockSize - len(plaintext)%blockSize
+	payloadLength := len(plaintext) + padding
+	ret, out := sliceForAppend(dst, payloadLength+a.macLenght)
+	payload := out[:payloadLength]
+
+	// Apply PKCS#7 padding & encrypt in place.
+	copy(payload, plaintext)
+	for i := len(plaintext); i < payloadLength; i++ {
+		payload[i] = byte(padding)
+	}
+	mode := cryptorCBCHMAC(a).blockMode(a.encrypters, cipher.NewCBCEncrypter, nonce)
+	mode.CryptBlocks(payload, payload)
+	a.encrypters.Put(mode)
+
+	// Append the MAC to the ciphertext.
+	mac := a.mac(nonce, payload, additionalData)
+	copy(out[payloadLength:], mac.sum)
+	a.macs.Put(mac)
+
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext & additionalData and appends the plaintext to dst.
+// To reuse ciphertext's storage for the output, use ciphertext[:0] as dst, otherwise dst must not overlap ciphertext.
+func (a aeadCBCHMAC) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != a.NonceSize() {
+		panic("libcipher/cipher: incorrect nonce length given to AES-CBC-HMAC")
+	}
+	blockSize := a.pher.BlockSize()
+	if len(ciphertext) < a.macLenght+blockSize || (len(ciphertext)-a.macLenght)%blockSize != 0 {
+		return nil, CipherTextError("cipherText is invalid")
+	}
+	payloadLength := len(ciphertext) - a.macLenght
+	payload := ciphertext[:payloadLength]
+
+	// Verify the MAC before touching the ciphertext.
+	mac := a.mac(nonce, payload, additionalData)
+	ok := hmac.Equal(mac.sum, ciphertext[payloadLength:])
+	a.macs.Put(mac)
+	if !ok {
+		return nil, CipherTextError("message authentication failed")
+	}
+
+	// Decrypt & remove the padding.
+	ret, out := sliceForAppend(dst, payloadLength)
+	mode := cryptorCBCHMAC(a).blockMode(a.decrypters, cipher.NewCBCDecrypter, nonce)
+	mode.CryptBlocks(out, payload)
+	a.decrypters.Put(mode)
+	unpadIndex, err := unpadPKCS7(out)
+	if err != nil {
+		clear(out)
+		return nil, err
+	}
+
+	return ret[:len(dst)+unpadIndex], nil
+}
+
+// mac calculates the MAC of the ciphertext into the sum of a pooled HMAC state, the caller has to put it back.
+func (a aeadCBCHMAC) mac(nonce []byte, ciphertext []byte, additionalData []byte) *pooledMAC {
+	mac := a.macs.Get().(*pooledMAC)
+	mac.Reset()
+	mac.Write(additionalData)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	// The sum buffer holds the AD length until it is replaced by the sum.
+	mac.sum = binary.BigEndian.AppendUint64(mac.sum[:0], uint64(len(additionalData))*8)
+	mac.Write(mac.sum)
+	mac.sum = mac.Sum(mac.sum[:0])
+
+	return mac
+}
@@ -0,0 +1,101 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestCBCHMACAEAD(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	aead, err := libcipher.NewCBCHMACAEAD(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aead.NonceSize() != aes.BlockSize || aead.Overhead() != sha256.Size+aes.BlockSize {
+		t.Fatalf("unexpected sizes: nonce %d, overhead %d", aead.NonceSize(), aead.Overhead())
+	}
+	additionalData := []byte("additional data")
+
+	for _, length := range []int{0, 1, 15, 16, 17, 1000} {
+		plaintext := bytes.Repeat([]byte{'x'}, length)
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			t.Fatal(err)
+		}
+		sealed := aead.Seal([]byte("prefix"), nonce, plaintext, additionalData)
+		if !bytes.HasPrefix(sealed, []byte("prefix")) {
+			t.Fatal("Seal did not append to dst")
+		}
+		ciphertext := sealed[len("prefix"):]
+		if len(ciphertext) > length+aead.Overhead() || (len(ciphertext)-sha256.Size)%aes.BlockSize != 0 {
+			t.Fatalf("unexpected ciphertext length %d for %d bytes", len(ciphertext), length)
+		}
+		opened, err := aead.Open(nil, nonce, ciphertext, additionalData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Fatalf("Open returned %q", opened)
+		}
+
+		// Nonce, AD & ciphertext are authenticated.
+		if _, err := aead.Open(nil, nonce, ciphertext, []byte("other data")); err == nil {
+			t.Fatal("Open accepted different additional data")
+		}
+		otherNonce := bytes.Clone(nonce)
+		otherNonce[0] ^= 1
+		if _, err := aead.Open(nil, otherNonce, ciphertext, additionalData); err == nil {
+			t.Fatal("Open accepted a different nonce")
+		}
+		for _, i := range []int{0, len(ciphertext) - 1} {
+			tampered := bytes.Clone(ciphertext)
+			tampered[i] ^= 1
+			if _, err := aead.Open(nil, nonce, tampered, additionalData); err == nil {
+				t.Fatalf("Open accepted a ciphertext tampered at %d", i)
+			}
+		}
+		if _, err := aead.Open(nil, nonce, ciphertext[:len(ciphertext)-1], additionalData); err == nil {
+			t.Fatal("Open accepted a truncated ciphertext")
+		}
+	}
+}
+
+func TestCBCHMACAEAD_InPlace(t *testing.T) {
+	aead, err := libcipher.NewCBCHMACAEAD(bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 64), sha512.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("This is some super secret data to encrypt.")
+	nonce := make([]byte, aead.NonceSize())
+
+	buffer := make([]byte, len(plaintext), len(plaintext)+aead.Overhead())
+	copy(buffer, plaintext)
+	sealed := aead.Seal(buffer[:0], nonce, buffer, nil)
+	if &sealed[0] != &buffer[0] {
+		t.Fatal("Seal did not reuse the plaintext storage")
+	}
+	opened, err := aead.Open(sealed[:0], nonce, sealed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open returned %q", opened)
+	}
+}
+
+func TestCBCHMACAEAD_Keys(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	if _, err := libcipher.NewCBCHMACAEAD(key, key, sha256.New); err == nil {
+		t.Fatal("expected an error for equal keys")
+	}
+	if _, err := libcipher.NewCBCHMACAEAD(key[:8], bytes.Repeat([]byte{2}, 32), sha256.New); err == nil {
+		t.Fatal("expected an error for a short encryption key")
+	}
+}
@@ -0,0 +1,186 @@
+package libcipher
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type (
+	ArmorError string
+)
+
+func (e ArmorError) Error() string {
+	return "libcipher/cipher: " + (string)(e)
+}
+
+// Lines enclosing an armored cipher package.
+const (
+	armorBegin = "-----BEGIN U8 CIPHER PACKAGE-----"
+	armorEnd   = "-----END U8 CIPHER PACKAGE-----"
+	// armorLineLength is the number of base64 characters per line.
+	armorLineLength = 64
+)
+
+// Names of the armor headers.
+const (
+	armorAlgorithmHeader = "Algorithm"
+	armorKeyIDHeader     = "Key-ID"
+)
+
+// EncodeArmor encodes a cipher package as text safe to paste into tickets, emails or YAML.
+//
+//	-----BEGIN U8 CIPHER PACKAGE-----
+//	Algorithm: aes-gcm
+//	Key-ID: 1
+//
+//	<base64, wrapped at 64 characters>
+//	=<base64 CRC-24 of the package>
+//	-----END U8 CIPHER PACKAGE-----
+//
+// The headers are informational copies of the package header, they are left out for headerless legacy packages.
+// The checksum follows OpenPGP (RFC 4880), it detects mangled text, not tampering.
+func EncodeArmor(cipherpackage []byte) []byte {
+	var armored bytes.Buffer
+	armored.WriteString(armorBegin + "\n")
+	if header, _, err := ParseHeader(cipherpackage); err == nil {
+		fmt.Fprintf(&armored, "%s: %s\n", armorAlgorithmHeader, header.Algorithm)
+		fmt.Fprintf(&armored, "%s: %d\n", armorKeyIDHeader, header.KeyID)
+	}
+	armored.WriteString("\n")
+
+	encoded := base64.StdEncoding.EncodeToString(cipherpackage)
+	for len(encoded) > armorLineLength {
+		armored.WriteString(encoded[:armorLineLength] + "\n")
+		encoded = encoded[armorLineLength:]
+	}
+	if len(encoded) > 0 {
+		armored.WriteString(encoded + "\n")
+	}
+
+	checksum := crc24(cipherpackage)
+	armored.WriteString("=" + base64.StdEncoding.EncodeToString([]byte{byte(checksum >> 16), byte(checksum >> 8), byte(checksum)}) + "\n")
+	armored.WriteString(armorEnd + "\n")
+
+	return armored.Bytes()
+}
+
+// IsArmored reports whether data contains the begin line of an armored cipher package.
+func IsArmored(data []byte) bool {
+	return bytes.Contains(data, []byte(armorBegin))
+}
+
+// DecodeArmor decodes the first armored cipher package found in data.
+// Text around the armor, indentation and CRLF line endings are ignored.
+// The checksum is verified and the headers have to match the package header.
+func DecodeArmor(data []byte) ([]byte, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	begin := -1
+	for i, line := range lines {
+		if line == armorBegin {
+			begin = i
+			break
+		}
+	}
+	if begin < 0 {
+		return nil, ArmorError("begin line not found")
+	}
+	lines = lines[begin+1:]
+
+	// Headers are terminated by an empty line.
+	headers := make(map[string]string)
+	for len(lines) > 0 && strings.Contains(lines[0], ": ") {
+		name, value, _ := strings.Cut(lines[0], ": ")
+		headers[name] = value
+		lines = lines[1:]
+	}
+	if len(lines) == 0 || len(lines[0]) != 0 {
+		return nil, ArmorError("missing empty line after the headers")
+	}
+	lines = lines[1:]
+
+	// Base64 lines up to the checksum & the end line.
+	var encoded strings.Builder
+	var checksum string
+	end := false
+	for _, line := range lines {
+		if line == armorEnd {
+			end = true
+			break
+		}
+		if len(checksum) != 0 {
+			return nil, ArmorError("unexpected data after the checksum")
+		}
+		if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+			continue
+		}
+		encoded.WriteString(line)
+	}
+	if !end {
+		return nil, ArmorError("end line not found")
+	}
+	if len(checksum) == 0 {
+		return nil, ArmorError("missing checksum")
+	}
+
+	cipherpackage, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ArmorError("invalid base64"), err)
+	}
+	expected, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || len(expected) != 3 {
+		return nil, ArmorError("invalid checksum")
+	}
+	sum := crc24(cipherpackage)
+	if expected[0] != byte(sum>>16) || expected[1] != byte(sum>>8) || expected[2] != byte(sum) {
+		return nil, ArmorError("checksum mismatch, the armored text was modified")
+	}
+	if err := checkArmorHeaders(headers, cipherpackage); err != nil {
+		return nil, err
+	}
+
+	return cipherpackage, nil
+}
+
+// checkArmorHeaders compares the armor headers with the header of the package.
+func checkArmorHeaders(headers map[string]string, cipherpackage []byte) error {
+	header, _, err := ParseHeader(cipherpackage)
+	if err != nil {
+		// Headerless legacy packages have nothing to compare.
+		return nil
+	}
+	if algorithm, ok := headers[armorAlgorithmHeader]; ok && algorithm != header.Algorithm.String() {
+		return ArmorError(fmt.Sprintf("algorithm header %s does not match the package", algorithm))
+	}
+	if keyID, ok := headers[armorKeyIDHeader]; ok && keyID != strconv.FormatUint(uint64(header.KeyID), 10) {
+		return ArmorError(fmt.Sprintf("key id header %s does not match the package", keyID))
+	}
+
+	return nil
+}
+
+// crc24 calculates the CRC-24 checksum of OpenPGP (RFC 4880, 6.1).
+func crc24(data []byte) uint32 {
+	const (
+		crc24Init = 0xb704ce
+		crc24Poly = 0x1864cfb
+	)
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+
+	return crc & 0xffffff
+}
@@ -0,0 +1,127 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestArmor_EncodeDecode(t *testing.T) {
+	keyring := libcipher.NewKeyring()
+	encryptor, decryptor := newTestGCM(t, []byte("mysecretencryptionkey12345671234"))
+	if err := keyring.Add(7, encryptor, decryptor); err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := keyring.Encryptor().Crypt(bytes.Repeat([]byte("message "), 20), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	armored := libcipher.EncodeArmor(cipherpackage)
+	text := string(armored)
+	if !strings.HasPrefix(text, "-----BEGIN U8 CIPHER PACKAGE-----\nAlgorithm: aes-gcm\nKey-ID: 7\n\n") ||
+		!strings.HasSuffix(text, "\n-----END U8 CIPHER PACKAGE-----\n") {
+		t.Fatalf("unexpected armor:\n%s", text)
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if len(line) > 64 {
+			t.Fatalf("line longer than 64 characters: %s", line)
+		}
+	}
+	if !libcipher.IsArmored(armored) || libcipher.IsArmored(cipherpackage) {
+		t.Fatal("IsArmored did not detect the armor")
+	}
+
+	var testCases = []struct {
+		name    string
+		armored string
+	}{
+		{name: "Plain", armored: text},
+		{name: "CRLF", armored: strings.ReplaceAll(text, "\n", "\r\n")},
+		{name: "Surrounded", armored: "Hi,\nplease find the secret below.\n\n" + text + "\nRegards"},
+		{name: "Indented", armored: "secret: |\n" + strings.ReplaceAll("  "+text, "\n", "\n  ")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded, err := libcipher.DecodeArmor([]byte(tc.armored))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decoded, cipherpackage) {
+				t.Fatal("decoded package doesn't match the original")
+			}
+			if _, _, err := keyring.Decryptor().Crypt(decoded); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestArmor_Mangled(t *testing.T) {
+	encryptor, err := libcipher.NewXChaChaEncryptor([]byte("mysecretencryptionkey12345671234"), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(libcipher.EncodeArmor(cipherpackage))
+	lines := strings.Split(text, "\n")
+	// Flip a character of the first base64 line.
+	data := []byte(lines[4])
+	if data[10] == 'A' {
+		data[10] = 'B'
+	} else {
+		data[10] = 'A'
+	}
+	mangled := strings.Join(append(append(append([]string{}, lines[:4]...), string(data)), lines[5:]...), "\n")
+
+	var testCases = []struct {
+		name    string
+		armored string
+	}{
+		{name: "Character", armored: mangled},
+		{name: "AlgorithmHeader", armored: strings.Replace(text, "xchacha20-poly1305", "aes-gcm", 1)},
+		{name: "KeyIDHeader", armored: strings.Replace(text, "Key-ID: 0", "Key-ID: 1", 1)},
+		{name: "Truncated", armored: strings.Join(append(append([]string{}, lines[:4]...), lines[5:]...), "\n")},
+		{name: "MissingBegin", armored: strings.Join(lines[1:], "\n")},
+		{name: "MissingEnd", armored: strings.Replace(text, "-----END U8 CIPHER PACKAGE-----", "", 1)},
+		{name: "MissingChecksum", armored: strings.Join(append(append([]string{}, lines[:len(lines)-3]...), lines[len(lines)-2:]...), "\n")},
+		{name: "Bare", armored: "bm90IGFybW9yZWQ="},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := libcipher.DecodeArmor([]byte(tc.armored)); err == nil {
+				t.Fatalf("expected an error for:\n%s", tc.armored)
+			}
+		})
+	}
+}
+
+func TestArmor_Legacy(t *testing.T) {
+	// Headerless packages are armored without headers.
+	legacy := []byte("a headerless legacy package")
+	armored := libcipher.EncodeArmor(legacy)
+	if !strings.HasPrefix(string(armored), "-----BEGIN U8 CIPHER PACKAGE-----\n\n") {
+		t.Fatalf("unexpected armor:\n%s", armored)
+	}
+	decoded, err := libcipher.DecodeArmor(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, legacy) {
+		t.Fatal("decoded package doesn't match the original")
+	}
+}
+
+func TestArmor_Checksum(t *testing.T) {
+	// The CRC-24 check value of "123456789" is 0x21cf02.
+	armored := string(libcipher.EncodeArmor([]byte("123456789")))
+	if !strings.Contains(armored, "\n=Ic8C\n") {
+		t.Fatalf("unexpected checksum:\n%s", armored)
+	}
+}
@@ -2,24 +2,25 @@ package libcipher
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/subtle"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"sync"
 )
 
 // Configure & init the AES-CBC+HMAC cryptor in encryption mode.
 // AES-CBC with PKCS7 padding HMAC for integrity.
 //
 //	The final encrypted string format:
-//	[ MAC | AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... ]
-//	rand.Reader is used for introducing randomness.
+//	[ Header | MAC | AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... ]
+//	rand.Reader is used for introducing randomness, NewCBCHMACEncryptorWithRand accepts another source.
 //
 // Don't use this for big messages, the whole cypher has to be in mem for computing the Hmac.
 //
@@ -37,7 +38,7 @@ import (
 //	forge HMACs, and tamper with the system without detection.
 //	Even if you rotate the encryption key, the integrity of past data is compromised.
 //
-// the MAC is calculated from ( AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... )
+// the MAC is calculated from ( Header | AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... )
 //
 // GCM Comparison:
 //
@@ -50,15 +51,28 @@ import (
 //
 // Since this is a one-person project, ensure you review the code before using it to validate its security and correctness.
 func NewCBCHMACEncryptor(encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash) (Encryptor, error) {
+	return NewCBCHMACEncryptorWithRand(encyptionKey, integrityKey, calculateMAC, rand.Reader)
+}
+
+// NewCBCHMACEncryptorWithRand is NewCBCHMACEncryptor reading the initialization vectors from rand.
+// Use a deterministic source for reproducible packages in tests only, IVs have to be unpredictable.
+func NewCBCHMACEncryptorWithRand(encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
 	cry, err := newCBCHMACryptor(encyptionKey, integrityKey, calculateMAC)
 	if err != nil {
 		return nil, err
 	}
+	cry.rand = rand
 
 	return (encryptorCBCHMAC)(cry), nil
 }
 
 // Configure & init the AES-CBC+HMAC cryptor in decryption mode.
+// Packages sealed before the header was introduced are still accepted and decrypted with the legacy layout:
+//
+//	[ MAC | AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... ]
 func NewCBCHMACDecryptor(encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash) (Decryptor, error) {
 	cry, err := newCBCHMACryptor(encyptionKey, integrityKey, calculateMAC)
 	if err != nil {
@@ -71,79 +85,114 @@ func NewCBCHMACDecryptor(encyptionKey []byte, integrityKey []byte, calculateMAC
 type encryptorCBCHMAC cryptorCBCHMAC
 
 func (crytor encryptorCBCHMAC) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	return crytor.Seal(nil, message, additionalData)
+}
+
+// Seal encrypts the message like Crypt and appends the package to dst.
+// Padding, encryption and MAC are calculated in place, reusing dst avoids allocations.
+// dst must not overlap message or additionalData.
+func (crytor encryptorCBCHMAC) Seal(dst []byte, message []byte, additionalData []byte) ([]byte, error) {
 	if message == nil {
 		return nil, MessageError("message was nil")
 	}
-	if len(additionalData) > maxAdditionalDataSize {
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
 		return nil, MessageError("additional data too large")
 	}
-	// Apply PKCS#7 padding to the input data.
-	pad := padPKCS7(len(message), crytor.pher.BlockSize())
-	payload := make([]byte, len(message)+len(pad))
-	// Prepare the message by concatenating the input and padding.
-	copy(payload[:len(message)], message)
-	copy(payload[len(message):], pad)
-	// Generate a random initialization vector (IV).
-	iv := make([]byte, crytor.pher.BlockSize())
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	blockSize := crytor.pher.BlockSize()
+	header := Header{Version: HeaderVersion2, Algorithm: AlgorithmCBCHMAC, Hash: crytor.hash, KeyID: crytor.keyID, Parameters: crytor.parameters}
+	headerLength := headerFixedLength + len(crytor.parameters)
+	adLengthSize := additionalDataLengthSize(header.Version)
+	padding := blockSize - len(message)%blockSize
+	// Calculate the total size needed for header, HMAC, additionalData header, additionalData, IV, encrypted data.
+	cypherLen := headerLength + crytor.macLenght + adLengthSize + len(additionalData) + blockSize + len(message) + padding
+	// Contruct slice to hold the encrypted text & Encrypt.
+	ret, cypherParcel := sliceForAppend(dst, cypherLen)
+	header.append(cypherParcel[:0])
+	// Encode AD length into bytes & copy it into the parcel.
+	macLocation := headerLength
+	adHeaderLocation := macLocation + crytor.macLenght
+	adLocation := adHeaderLocation + adLengthSize
+	putAdditionalDataLength(cypherParcel[adHeaderLocation:adLocation], header.Version, len(additionalData))
+	ivLocation := adLocation + len(additionalData)
+	copy(cypherParcel[adLocation:ivLocation], additionalData)
+	// Generate a random initialization vector (IV) after the additional data.
+	cipherTextLocation := ivLocation + blockSize
+	iv := cypherParcel[ivLocation:cipherTextLocation]
+	if _, err := io.ReadFull(crytor.rand, iv); err != nil {
 		return nil, err
 	}
+	// Apply PKCS#7 padding to the message.
+	payload := cypherParcel[cipherTextLocation:]
+	copy(payload, message)
+	for i := len(message); i < len(payload); i++ {
+		payload[i] = byte(padding)
+	}
+	// Take a CBC encrypter from the pool and encrypt the message.
+	mode := cryptorCBCHMAC(crytor).blockMode(crytor.encrypters, cipher.NewCBCEncrypter, iv)
+	mode.CryptBlocks(payload, payload)
+	crytor.encrypters.Put(mode)
+	// Calculate the HMAC signature over the header and everything following the HMAC, store it right after the header.
+	cryptorCBCHMAC(crytor).sum(cypherParcel[macLocation:adHeaderLocation], cypherParcel[:macLocation], cypherParcel[adHeaderLocation:])
 
-	return crytor.seal(iv, payload, additionalData), nil
+	return ret, nil
 }
 
-func (crytor encryptorCBCHMAC) seal(iv []byte, plaintext []byte, additionalData []byte) []byte {
-	// Calculate the total size needed for HMAC, additionalData header, additionalData, IV, encrypted data.
-	cypherLen := len(plaintext) + crytor.pher.BlockSize() + crytor.macLenght + additionalDataHeaderLength + len(additionalData)
-	// Contruct slice to hold the encrypted text & Encrypt.
-	cypherParcel := make([]byte, cypherLen)
-	// Calculate AD length
-	adLength := uint16(len(additionalData))
-	// Encode AD length into bytes & copy it into the parcel.
-	adHeaderLocation := crytor.macLenght
-	adLocation := adHeaderLocation + additionalDataHeaderLength
-	binary.BigEndian.PutUint16(cypherParcel[adHeaderLocation:adLocation], adLength)
-	ivLocation := adLocation + len(additionalData)
-	copy(cypherParcel[adLocation:ivLocation], additionalData)
-	// Store the IV after HMAC to the destination buffer.
-	cipherTextLocation := ivLocation + len(iv)
-	copy(cypherParcel[ivLocation:cipherTextLocation], iv)
-	// Create a CBC decrypter and encrypt the message.
-	mode := cipher.NewCBCEncrypter(crytor.pher, iv)
-	mode.CryptBlocks(cypherParcel[cipherTextLocation:], plaintext)
-	// Calculate the HMAC signature.
-	hmac := generateSignature(crytor.integrityKey, crytor.calcMac, cypherParcel[adHeaderLocation:]...)
-	// Store the HMAC at the beginning of destination buffer.
-	copy(cypherParcel[:adHeaderLocation], hmac)
-
-	return cypherParcel
+// withKeyID returns a copy of the encryptor stamping id into the header of its packages.
+func (crytor encryptorCBCHMAC) withKeyID(id uint32) Encryptor {
+	crytor.keyID = id
+	return crytor
 }
 
 // Decryption mode of the cryptor.
 type decryptorCBCHMAC cryptorCBCHMAC
 
+// Crypt decrypts a cipher package, packages without a header are decrypted with the legacy layout.
 func (cryptor decryptorCBCHMAC) Crypt(ciphertext []byte) ([]byte, []byte, error) {
+	return cryptor.Open(nil, ciphertext)
+}
+
+// Open decrypts a cipher package like Crypt and appends the message to dst.
+// The returned additional data is a slice of the package, reusing dst avoids allocations.
+// dst must not overlap the package.
+func (cryptor decryptorCBCHMAC) Open(dst []byte, ciphertext []byte) ([]byte, []byte, error) {
 	if ciphertext == nil {
 		return nil, nil, CipherTextError("cipherText was nil")
 	}
+	header, headerLength, err := ParseHeader(ciphertext)
+	if err != nil {
+		// Legacy package: [ MAC | AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... ]
+		return cryptor.open(dst, HeaderVersion1, nil, ciphertext)
+	}
+	if err := header.check(AlgorithmCBCHMAC, cryptor.hash); err != nil {
+		return nil, nil, err
+	}
+
+	return cryptor.open(dst, header.Version, ciphertext[:headerLength], ciphertext[headerLength:])
+}
+
+// open verifies & decrypts the part of a package following its header, the message is appended to dst.
+// The version defines the size of the additional data length.
+func (cryptor decryptorCBCHMAC) open(dst []byte, version byte, header []byte, ciphertext []byte) ([]byte, []byte, error) {
 	if len(ciphertext) < cryptor.macLenght+cryptor.pher.BlockSize() {
 		return nil, nil, CipherTextError("cipherText is invalid")
 	}
-	minCiphertextSize := cryptor.macLenght + additionalDataHeaderLength + cryptor.pher.BlockSize()
+	adLengthSize := additionalDataLengthSize(version)
+	minCiphertextSize := cryptor.macLenght + adLengthSize + cryptor.pher.BlockSize()
 	if len(ciphertext) < minCiphertextSize {
 		return nil, nil, CipherTextError("cipherText is too short")
 	}
 	// Extract the HMAC from the beginning of the encrypted data.
 	adHeaderLocation := cryptor.macLenght
 	mac := ciphertext[:adHeaderLocation]
-	err := verify(mac, cryptor.integrityKey, cryptor.calcMac, generateSignature, ciphertext[adHeaderLocation:]...)
-	if err != nil {
-		return nil, nil, fmt.Errorf("data integrity compromised %w", err)
+	if !cryptorCBCHMAC(cryptor).verify(mac, header, ciphertext[adHeaderLocation:]) {
+		return nil, nil, fmt.Errorf("data integrity compromised %w", errors.New("signature verification failed"))
 	}
 	// Extract additionalData lenght.
-	adLocation := adHeaderLocation + additionalDataHeaderLength
-	adLengthHeader := ciphertext[adHeaderLocation:adLocation]
-	adLength := binary.BigEndian.Uint16(adLengthHeader)
+	adLocation := adHeaderLocation + adLengthSize
+	adLength := additionalDataLength(ciphertext[adHeaderLocation:adLocation], version)
+	if adLength > uint64(len(ciphertext)-minCiphertextSize) {
+		return nil, nil, CipherTextError("cipherText is too short for additional data")
+	}
 	// Exctract the additional data.
 	ivLocation := adLocation + int(adLength)
 	additionalData := ciphertext[adLocation:ivLocation]
@@ -151,29 +200,88 @@ func (cryptor decryptorCBCHMAC) Crypt(ciphertext []byte) ([]byte, []byte, error)
 	cipherTextLocation := ivLocation + cryptor.pher.BlockSize()
 	iv := ciphertext[ivLocation:cipherTextLocation]
 	payload := ciphertext[cipherTextLocation:]
+	if len(payload)%cryptor.pher.BlockSize() != 0 {
+		return nil, nil, CipherTextError("cipherText is not a multiple of the block size")
+	}
 
-	// Init slice to hold the plaintext & decrypt.
-	dst := make([]byte, len(payload))
-	// Create a CBC decrypter and decrypt the message.
-	mode := cipher.NewCBCDecrypter(cryptor.pher, iv)
-	mode.CryptBlocks(dst, payload)
+	// Extend dst to hold the plaintext & decrypt.
+	ret, out := sliceForAppend(dst, len(payload))
+	// Take a CBC decrypter from the pool and decrypt the message.
+	mode := cryptorCBCHMAC(cryptor).blockMode(cryptor.decrypters, cipher.NewCBCDecrypter, iv)
+	mode.CryptBlocks(out, payload)
+	cryptor.decrypters.Put(mode)
 	// Calculate the padding index.
-	unpadIndex, err := unpadPKCS7(dst)
+	unpadIndex, err := unpadPKCS7(out)
 	if err != nil {
 		return nil, nil, err
 	}
-	message := make([]byte, unpadIndex)
-	// Remove padding & return.
-	copy(message, dst[:unpadIndex])
 
-	return message, additionalData, nil
+	// Remove padding & return.
+	return ret[:len(dst)+unpadIndex], additionalData, nil
 }
 
 type cryptorCBCHMAC struct {
 	pher         cipher.Block
 	macLenght    int
 	calcMac      func() hash.Hash
+	hash         crypto.Hash
 	integrityKey []byte
+	keyID        uint32
+	parameters   []byte
+	rand         io.Reader
+	// macs pools keyed HMAC states together with a buffer for their sum.
+	macs *sync.Pool
+	// encrypters & decrypters pool the CBC block modes, their IV is reset on every use.
+	encrypters *sync.Pool
+	decrypters *sync.Pool
+}
+
+// ivSetter is implemented by the CBC block modes of crypto/cipher, it allows to reuse them for another IV.
+type ivSetter interface {
+	SetIV([]byte)
+}
+
+// blockMode takes a CBC block mode from pool and resets it to iv.
+// A block mode without SetIV can't be reused, a fresh one is created by newMode instead.
+func (cryptor cryptorCBCHMAC) blockMode(pool *sync.Pool, newMode func(cipher.Block, []byte) cipher.BlockMode, iv []byte) cipher.BlockMode {
+	mode := pool.Get().(cipher.BlockMode)
+	if setter, ok := mode.(ivSetter); ok {
+		setter.SetIV(iv)
+		return mode
+	}
+
+	return newMode(cryptor.pher, iv)
+}
+
+// pooledMAC is a keyed HMAC state held by the pool of a cryptor.
+type pooledMAC struct {
+	hash.Hash
+	sum []byte
+}
+
+// sum calculates the HMAC over parts into out, which has to be of the size of the MAC.
+func (cryptor cryptorCBCHMAC) sum(out []byte, parts ...[]byte) {
+	mac := cryptor.macs.Get().(*pooledMAC)
+	mac.Reset()
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	mac.Sum(out[:0])
+	cryptor.macs.Put(mac)
+}
+
+// verify reports whether expected is the HMAC over parts, in constant time.
+func (cryptor cryptorCBCHMAC) verify(expected []byte, parts ...[]byte) bool {
+	mac := cryptor.macs.Get().(*pooledMAC)
+	mac.Reset()
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	mac.sum = mac.Sum(mac.sum[:0])
+	ok := hmac.Equal(mac.sum, expected)
+	cryptor.macs.Put(mac)
+
+	return ok
 }
 
 // Configure & init the AES-CBC+HMAC Cryptor in encryption mode.
@@ -199,11 +307,24 @@ func newCBCHMACryptor(encyptionKey []byte, integrityKey []byte, calculateMAC fun
 
 	newintegrityKey := make([]byte, len(integrityKey))
 	copy(newintegrityKey, integrityKey)
+	macLength := calculateMAC().Size()
+	macs := &sync.Pool{New: func() any {
+		return &pooledMAC{Hash: hmac.New(calculateMAC, newintegrityKey), sum: make([]byte, 0, macLength)}
+	}}
+	// The IV of pooled block modes is always reset before use.
+	zeroIV := make([]byte, block.BlockSize())
+	encrypters := &sync.Pool{New: func() any { return cipher.NewCBCEncrypter(block, zeroIV) }}
+	decrypters := &sync.Pool{New: func() any { return cipher.NewCBCDecrypter(block, zeroIV) }}
 	return cryptorCBCHMAC{
 		pher:         block,
-		macLenght:    calculateMAC().Size(),
+		macLenght:    macLength,
+		hash:         identifyHash(calculateMAC),
 		integrityKey: newintegrityKey,
 		calcMac:      calculateMAC,
+		rand:         rand.Reader,
+		macs:         macs,
+		encrypters:   encrypters,
+		decrypters:   decrypters,
 	}, nil
 }
 
@@ -243,6 +364,16 @@ func generateSignature(token []byte, hashing func() hash.Hash, message ...byte)
 	return h.Sum(nil)
 }
 
+// generateSignatureParts generates HMAC for a message split into several parts.
+func generateSignatureParts(token []byte, hashing func() hash.Hash, parts ...[]byte) []byte {
+	h := hmac.New(hashing, token)
+	for _, part := range parts {
+		h.Write(part)
+	}
+
+	return h.Sum(nil)
+}
+
 // verify verifies the integrity of the message using HMAC.
 func verify(hmac, token []byte, hashing func() hash.Hash, should func(token []byte, hashing func() hash.Hash, message ...byte) []byte, message ...byte) error {
 	// Constant-time comparison to mitigate timing attacks.
@@ -254,4 +385,9 @@ func verify(hmac, token []byte, hashing func() hash.Hash, should func(token []by
 }
 
 const additionalDataHeaderLength = 2
+
+// maxAdditionalDataSize is the limit of the 2 byte additional data length of streams and version 1 packages.
 const maxAdditionalDataSize = 65535
+
+// maxPackageAdditionalDataSize is the limit of the 4 byte additional data length of version 2 packages.
+const maxPackageAdditionalDataSize = 1<<32 - 1
@@ -14,6 +14,20 @@ type Decryptor interface {
 	Crypt(cipherpackage []byte) ([]byte, []byte, error)
 }
 
+// provides a method to encrypt a message into a caller-provided buffer.
+// Implemented by the AES-CBC+HMAC and AES-GCM encryptors.
+type Sealer interface {
+	// Encrypts a message like Crypt and appends the cipher package to dst.
+	Seal(dst []byte, message []byte, additionalData []byte) ([]byte, error)
+}
+
+// provides a method to decrypt a cipher package into a caller-provided buffer.
+// Implemented by the AES-CBC+HMAC and AES-GCM decryptors.
+type Opener interface {
+	// Decrypts a cipher package like Crypt and appends the message to dst.
+	Open(dst []byte, cipherpackage []byte) ([]byte, []byte, error)
+}
+
 type (
 	MessageError       string
 	CipherTextError    string
@@ -0,0 +1,195 @@
+// Package ciphertest runs a standard battery of tests against libcipher.Encryptor & libcipher.Decryptor implementations.
+package ciphertest
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+// DefaultMaxAdditionalData is the largest additional data tested if Config leaves it unset.
+// It exceeds the 2 byte AD length of version 1 packages.
+const DefaultMaxAdditionalData = 1 << 16
+
+// Config describes the cryptors under test.
+type Config struct {
+	// New returns an Encryptor & a Decryptor sharing a key, it is called once per test.
+	New func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor)
+	// NewWrongKey returns a Decryptor for another key of the same kind, the wrong key test is skipped if nil.
+	NewWrongKey func(tb testing.TB) libcipher.Decryptor
+	// MaxAdditionalData is the largest additional data the cryptors have to handle, 0 uses DefaultMaxAdditionalData.
+	MaxAdditionalData int
+	// Deterministic marks cryptors sealing equal inputs to equal packages, e.g. deterministic AES-SIV.
+	Deterministic bool
+}
+
+// messages are the plaintexts every test is run with, they cover empty and partial, full & multiple blocks.
+var messages = [][]byte{
+	{},
+	[]byte("a"),
+	[]byte("exactly 16 bytes"),
+	[]byte("This is some super secret data to encrypt."),
+	bytes.Repeat([]byte{0x5a}, 1000),
+}
+
+// Run tests the cryptors of config, every check is run as a subtest:
+//
+//   - RoundTrip: messages & additional data are returned unchanged, also for empty messages & additional data.
+//   - Randomized: equal inputs yield different packages, unless the cryptors are deterministic.
+//   - AdditionalDataBinding: the additional data is returned as sealed and changes the package.
+//   - MaxAdditionalData: the largest additional data round trips.
+//   - BitFlip: flipping any bit of any byte of a package is detected.
+//   - Truncation: every proper prefix of a package is rejected.
+//   - Extension: bytes appended to a package are rejected.
+//   - Nil: a nil message or package is rejected with an error instead of a panic.
+//   - WrongKey: a package is rejected by a Decryptor of another key.
+func Run(t *testing.T, config Config) {
+	if config.New == nil {
+		t.Fatal("ciphertest: Config.New is required")
+	}
+	maxAdditionalData := config.MaxAdditionalData
+	if maxAdditionalData == 0 {
+		maxAdditionalData = DefaultMaxAdditionalData
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		for _, message := range messages {
+			for _, additionalData := range [][]byte{nil, {}, []byte("additional data")} {
+				cipherpackage := seal(t, encryptor, message, additionalData)
+				open(t, decryptor, cipherpackage, message, additionalData)
+			}
+		}
+	})
+
+	t.Run("Randomized", func(t *testing.T) {
+		encryptor, _ := config.New(t)
+		for _, message := range messages {
+			first := seal(t, encryptor, message, nil)
+			second := seal(t, encryptor, message, nil)
+			if equal := bytes.Equal(first, second); equal != config.Deterministic {
+				t.Fatalf("sealing %d bytes twice: equal packages %t, deterministic %t", len(message), equal, config.Deterministic)
+			}
+		}
+	})
+
+	t.Run("AdditionalDataBinding", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		message := messages[3]
+		first := seal(t, encryptor, message, []byte("additional data 1"))
+		second := seal(t, encryptor, message, []byte("additional data 2"))
+		open(t, decryptor, first, message, []byte("additional data 1"))
+		open(t, decryptor, second, message, []byte("additional data 2"))
+		if bytes.Equal(first, second) {
+			t.Fatal("different additional data yields equal packages")
+		}
+	})
+
+	t.Run("MaxAdditionalData", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		additionalData := bytes.Repeat([]byte{0xa5}, maxAdditionalData)
+		cipherpackage := seal(t, encryptor, messages[3], additionalData)
+		open(t, decryptor, cipherpackage, messages[3], additionalData)
+	})
+
+	t.Run("BitFlip", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		for _, message := range messages[:4] {
+			cipherpackage := seal(t, encryptor, message, []byte("additional data"))
+			for i := range cipherpackage {
+				for _, bit := range []byte{0x01, 0x80} {
+					tampered := bytes.Clone(cipherpackage)
+					tampered[i] ^= bit
+					reject(t, decryptor, tampered, fmt.Sprintf("%d byte message with bit %#x of byte %d flipped", len(message), bit, i))
+				}
+			}
+		}
+	})
+
+	t.Run("Truncation", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		for _, message := range messages[:4] {
+			cipherpackage := seal(t, encryptor, message, []byte("additional data"))
+			for i := 0; i < len(cipherpackage); i++ {
+				reject(t, decryptor, bytes.Clone(cipherpackage[:i]), fmt.Sprintf("%d byte message truncated to %d bytes", len(message), i))
+			}
+		}
+	})
+
+	t.Run("Extension", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		cipherpackage := seal(t, encryptor, messages[3], []byte("additional data"))
+		for _, suffix := range [][]byte{{0}, bytes.Repeat([]byte{0x10}, 16)} {
+			extended := append(bytes.Clone(cipherpackage), suffix...)
+			reject(t, decryptor, extended, fmt.Sprintf("package extended by %d bytes", len(suffix)))
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		encryptor, decryptor := config.New(t)
+		func() {
+			defer recoverPanic(t, "Crypt with a nil message")
+			if _, err := encryptor.Crypt(nil, nil); err == nil {
+				t.Error("Crypt accepted a nil message")
+			}
+		}()
+		reject(t, decryptor, nil, "nil package")
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		if config.NewWrongKey == nil {
+			t.Skip("no Config.NewWrongKey")
+		}
+		encryptor, _ := config.New(t)
+		decryptor := config.NewWrongKey(t)
+		for _, message := range messages {
+			cipherpackage := seal(t, encryptor, message, []byte("additional data"))
+			reject(t, decryptor, cipherpackage, fmt.Sprintf("%d byte message under the wrong key", len(message)))
+		}
+	})
+}
+
+// seal encrypts the message or fails the test.
+func seal(t *testing.T, encryptor libcipher.Encryptor, message []byte, additionalData []byte) []byte {
+	t.Helper()
+	cipherpackage, err := encryptor.Crypt(message, additionalData)
+	if err != nil {
+		t.Fatalf("sealing %d bytes with %d bytes of additional data: %v", len(message), len(additionalData), err)
+	}
+
+	return cipherpackage
+}
+
+// open decrypts the package and compares the message & additional data.
+func open(t *testing.T, decryptor libcipher.Decryptor, cipherpackage []byte, message []byte, additionalData []byte) {
+	t.Helper()
+	decrypted, ad, err := decryptor.Crypt(cipherpackage)
+	if err != nil {
+		t.Fatalf("opening %d bytes with %d bytes of additional data: %v", len(message), len(additionalData), err)
+	}
+	if !bytes.Equal(decrypted, message) {
+		t.Fatalf("decrypted message doesn't match the original: %q", decrypted)
+	}
+	if !bytes.Equal(ad, additionalData) {
+		t.Fatalf("additional data doesn't match the original: got %d bytes, sealed %d", len(ad), len(additionalData))
+	}
+}
+
+// reject fails the test if the package is decrypted or the decryptor panics.
+func reject(t *testing.T, decryptor libcipher.Decryptor, cipherpackage []byte, description string) {
+	t.Helper()
+	defer recoverPanic(t, description)
+	if _, _, err := decryptor.Crypt(cipherpackage); err == nil {
+		t.Fatalf("accepted %s", description)
+	}
+}
+
+// recoverPanic turns a panic of a cryptor into a test failure.
+func recoverPanic(t *testing.T, description string) {
+	t.Helper()
+	if r := recover(); r != nil {
+		t.Fatalf("panic on %s: %v", description, r)
+	}
+}
@@ -0,0 +1,191 @@
+package ciphertest_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+	"github.com/u8717/crypt/libcipher/ciphertest"
+)
+
+// algorithmConfig tests the algorithm registered under name with keys derived from master.
+func algorithmConfig(name string) ciphertest.Config {
+	newPair := func(tb testing.TB, master string) (libcipher.Encryptor, libcipher.Decryptor) {
+		keys, err := libcipher.DeriveAlgorithmKeys(name, []byte(master), nil, "ciphertest")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		encryptor, err := libcipher.NewEncryptor(name, keys...)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		decryptor, err := libcipher.NewDecryptor(name, keys...)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return encryptor, decryptor
+	}
+
+	return ciphertest.Config{
+		New: func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			return newPair(tb, "a master key of at least 16 bytes")
+		},
+		NewWrongKey: func(tb testing.TB) libcipher.Decryptor {
+			_, decryptor := newPair(tb, "another master key of 16 bytes")
+			return decryptor
+		},
+		Deterministic: name == "aes-256-siv-deterministic",
+	}
+}
+
+func TestRegisteredAlgorithms(t *testing.T) {
+	for _, name := range libcipher.Algorithms() {
+		t.Run(name, func(t *testing.T) {
+			ciphertest.Run(t, algorithmConfig(name))
+		})
+	}
+}
+
+func TestX25519(t *testing.T) {
+	newIdentity := func(tb testing.TB) libcipher.Decryptor {
+		identity, err := libcipher.GenerateX25519Identity(rand.Reader)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		decryptor, err := libcipher.NewX25519Decryptor(identity)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return decryptor
+	}
+	ciphertest.Run(t, ciphertest.Config{
+		New: func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			identity, err := libcipher.GenerateX25519Identity(rand.Reader)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			encryptor, err := libcipher.NewX25519Encryptor(identity.PublicKey(), rand.Reader)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			decryptor, err := libcipher.NewX25519Decryptor(identity)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return encryptor, decryptor
+		},
+		NewWrongKey: newIdentity,
+	})
+}
+
+func TestEnvelope(t *testing.T) {
+	kek := bytes.Repeat([]byte{1}, 32)
+	ciphertest.Run(t, ciphertest.Config{
+		New: func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			encryptor, err := libcipher.NewEnvelopeEncryptor(kek, 1, "aes-256-gcm", rand.Reader)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			decryptor, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{1: kek})
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return encryptor, decryptor
+		},
+		NewWrongKey: func(tb testing.TB) libcipher.Decryptor {
+			decryptor, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{1: bytes.Repeat([]byte{2}, 32)})
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return decryptor
+		},
+	})
+}
+
+func TestMultiRecipient(t *testing.T) {
+	newRecipient := func(tb testing.TB, kek byte) *libcipher.SymmetricRecipient {
+		recipient, err := libcipher.NewSymmetricRecipient(1, bytes.Repeat([]byte{kek}, 32))
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return recipient
+	}
+	ciphertest.Run(t, ciphertest.Config{
+		New: func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			recipient := newRecipient(tb, 1)
+			encryptor, err := libcipher.NewMultiRecipientEncryptor([]libcipher.Recipient{recipient}, rand.Reader)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			decryptor, err := libcipher.NewMultiRecipientDecryptor(recipient)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return encryptor, decryptor
+		},
+		NewWrongKey: func(tb testing.TB) libcipher.Decryptor {
+			decryptor, err := libcipher.NewMultiRecipientDecryptor(newRecipient(tb, 2))
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return decryptor
+		},
+	})
+}
+
+func TestSigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := algorithmConfig("xchacha20-poly1305")
+	newVerifier := func(tb testing.TB, decryptor libcipher.Decryptor, publicKey ed25519.PublicKey) libcipher.Decryptor {
+		verifier, err := libcipher.NewVerifier(decryptor, map[uint32]ed25519.PublicKey{1: publicKey})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return verifier
+	}
+	ciphertest.Run(t, ciphertest.Config{
+		New: func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			encryptor, decryptor := inner.New(tb)
+			signer, err := libcipher.NewSigner(encryptor, 1, privateKey)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return signer, newVerifier(tb, decryptor, publicKey)
+		},
+		// The right decryption key with the wrong verification key.
+		NewWrongKey: func(tb testing.TB) libcipher.Decryptor {
+			_, decryptor := inner.New(tb)
+			return newVerifier(tb, decryptor, otherPublicKey)
+		},
+	})
+}
+
+func TestKeyring(t *testing.T) {
+	inner := algorithmConfig("aes-256-gcm")
+	newKeyring := func(tb testing.TB, id uint32) *libcipher.Keyring {
+		encryptor, decryptor := inner.New(tb)
+		keyring := libcipher.NewKeyring()
+		if err := keyring.Add(id, encryptor, decryptor); err != nil {
+			tb.Fatal(err)
+		}
+		return keyring
+	}
+	ciphertest.Run(t, ciphertest.Config{
+		New: func(tb testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			keyring := newKeyring(tb, 1)
+			return keyring.Encryptor(), keyring.Decryptor()
+		},
+		NewWrongKey: func(tb testing.TB) libcipher.Decryptor {
+			return newKeyring(tb, 2).Decryptor()
+		},
+	})
+}
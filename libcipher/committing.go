@@ -0,0 +1,102 @@
+package libcipher
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+)
+
+// Size & context of the key commitment of the committing AES-GCM cryptor.
+const (
+	commitmentSize    = sha256.Size
+	commitmentContext = "libcipher/gcm/commitment"
+)
+
+// NewCommittingGCMEncryptor creates a new Encryptor using key-committing AES-GCM with the given key.
+//
+//	The final encrypted string format:
+//	[ Header | Nonce | AD-Lenght | AD | Commitment (32 bytes) | Ciphertext | Authentication Tag ]
+//
+// AES-GCM alone is not key-committing, a crafted package can decrypt successfully under several keys.
+// This enables partitioning-oracle attacks whenever the key is picked by trial, e.g. from a keyring or a password.
+// The commitment is derived by HKDF-SHA256 from the key, salted with the nonce, and checked in constant time before GCM is opened,
+// a package only decrypts under the key that sealed it.
+//
+// The packages are not compatible with NewGCMEncryptor, they are marked as their own algorithm.
+func NewCommittingGCMEncryptor(encyptionKey []byte, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	cryptor, err := newCommittingGCMCryptor(encyptionKey)
+	if err != nil {
+		return nil, err
+	}
+	cryptor.useRandomNonces(rand)
+
+	return (encryptorGCM)(cryptor), nil
+}
+
+// NewCommittingGCMDecryptor creates a new Decryptor using key-committing AES-GCM with the given key.
+func NewCommittingGCMDecryptor(encyptionKey []byte) (Decryptor, error) {
+	cryptor, err := newCommittingGCMCryptor(encyptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return (decryptorGCM)(cryptor), nil
+}
+
+func newCommittingGCMCryptor(encyptionKey []byte) (cryptorGCM, error) {
+	cryptor, err := newGCMCryptor(encyptionKey)
+	if err != nil {
+		return cryptorGCM{}, err
+	}
+	cryptor.gcm = committingAEAD{AEAD: cryptor.gcm, key: bytes.Clone(encyptionKey)}
+	cryptor.algorithm = AlgorithmCommittingGCM
+
+	return cryptor, nil
+}
+
+// committingAEAD prefixes the ciphertext of an AEAD with a commitment to its key.
+type committingAEAD struct {
+	cipher.AEAD
+	key []byte
+}
+
+func (a committingAEAD) Overhead() int {
+	return commitmentSize + a.AEAD.Overhead()
+}
+
+// Seal appends the commitment followed by the sealed plaintext to dst.
+func (a committingAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	commitment, err := a.commitment(nonce)
+	if err != nil {
+		// The key size was checked on construction, deriving can't fail.
+		panic(err)
+	}
+
+	return a.AEAD.Seal(append(dst, commitment...), nonce, plaintext, additionalData)
+}
+
+// Open checks the commitment in constant time before the ciphertext is opened.
+func (a committingAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < a.Overhead() {
+		return nil, CipherTextError("cipherText is too short")
+	}
+	commitment, err := a.commitment(nonce)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(commitment, ciphertext[:commitmentSize]) != 1 {
+		return nil, CipherTextError("key commitment mismatch")
+	}
+
+	return a.AEAD.Open(dst, nonce, ciphertext[commitmentSize:], additionalData)
+}
+
+// commitment derives the commitment to the key for a nonce.
+func (a committingAEAD) commitment(nonce []byte) ([]byte, error) {
+	return DeriveKey(a.key, nonce, commitmentContext, commitmentSize, sha256.New)
+}
@@ -0,0 +1,140 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestCommittingGCM_EncryptDecrypt(t *testing.T) {
+	key := []byte("mysecretencryptionkey12345671234")
+	encryptor, err := libcipher.NewCommittingGCMEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewCommittingGCMDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range [][]byte{{}, []byte("This is some super secret data to encrypt.")} {
+		cipherpackage, err := encryptor.Crypt(message, []byte("additional data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, err := libcipher.ParseHeader(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Algorithm != libcipher.AlgorithmCommittingGCM {
+			t.Fatalf("unexpected algorithm %s", header.Algorithm)
+		}
+		decrypted, ad, err := decryptor.Crypt(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, message) || string(ad) != "additional data" {
+			t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+		}
+	}
+
+	if _, err := encryptor.Crypt(nil, nil); err == nil {
+		t.Fatal("expected an error for a nil message")
+	}
+	if _, err := libcipher.NewCommittingGCMEncryptor([]byte("too_short"), rand.Reader); err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+}
+
+func TestCommittingGCM_Commitment(t *testing.T) {
+	key := []byte("mysecretencryptionkey12345671234")
+	encryptor, err := libcipher.NewCommittingGCMEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Header (13 bytes) | Nonce (12 bytes) | AD-Length (4 bytes) | AD (2 bytes) | Commitment
+	const commitmentLocation = 13 + 12 + 4 + 2
+
+	// Another key fails on the commitment before GCM is opened.
+	otherDecryptor, err := libcipher.NewCommittingGCMDecryptor([]byte("anothersecretencryptionkey123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cipherTextError libcipher.CipherTextError
+	if _, _, err := otherDecryptor.Crypt(cipherpackage); !errors.As(err, &cipherTextError) || cipherTextError != "key commitment mismatch" {
+		t.Fatalf("expected a commitment mismatch, got %v", err)
+	}
+
+	// The commitment & ciphertext can't be modified.
+	decryptor, err := libcipher.NewCommittingGCMDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{commitmentLocation, commitmentLocation + 31, len(cipherpackage) - 1} {
+		tampered := bytes.Clone(cipherpackage)
+		tampered[i] ^= 1
+		if _, _, err := decryptor.Crypt(tampered); err == nil {
+			t.Fatalf("tampering at %d was not detected", i)
+		}
+	}
+	if _, _, err := decryptor.Crypt(cipherpackage[:commitmentLocation+16]); err == nil {
+		t.Fatal("expected an error for a truncated package")
+	}
+
+	// Plain & committing GCM packages are not interchangeable.
+	gcmDecryptor, err := libcipher.NewGCMDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gcmDecryptor.Crypt(cipherpackage); err == nil {
+		t.Fatal("GCM decryptor accepted a committing package")
+	}
+	gcmEncryptor, err := libcipher.NewGCMEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmPackage, err := gcmEncryptor.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := decryptor.Crypt(gcmPackage); err == nil {
+		t.Fatal("committing decryptor accepted a GCM package")
+	}
+	if _, _, err := decryptor.Crypt(gcmPackage[13:]); err == nil {
+		t.Fatal("committing decryptor accepted a headerless package")
+	}
+}
+
+func TestCommittingGCM_Keyring(t *testing.T) {
+	keyring := libcipher.NewKeyring()
+	for id, key := range map[uint32][]byte{1: []byte("mysecretencryptionkey12345671234"), 2: []byte("anothersecretencryptionkey123456")} {
+		encryptor, err := libcipher.NewCommittingGCMEncryptor(key, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decryptor, err := libcipher.NewCommittingGCMDecryptor(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.Add(id, encryptor, decryptor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := keyring.SetActive(2); err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := keyring.Encryptor().Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := keyring.Decryptor().Crypt(cipherpackage); err != nil {
+		t.Fatal(err)
+	}
+}
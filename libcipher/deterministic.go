@@ -0,0 +1,74 @@
+package libcipher
+
+import (
+	"crypto/cipher"
+)
+
+// cryptorDeterministic implements the Encryptor and Decryptor interfaces using deterministic AES-SIV.
+type cryptorDeterministic struct {
+	aead  cipher.AEAD
+	keyID uint32
+}
+
+// Encryption mode.
+type encryptorDeterministic cryptorDeterministic
+
+// Decryption mode.
+type decryptorDeterministic cryptorDeterministic
+
+// NewDeterministicEncryptor creates an Encryptor which always produces the same package
+// for the same key, additional data and message, using AES-SIV (RFC 5297) without a nonce.
+// The key has to be 32, 48 or 64 bytes.
+//
+//	The final encrypted string format:
+//	[ Header | AD-Lenght | AD | Synthetic IV | Ciphertext ]
+//
+// Only use this where equality of ciphertexts is required, e.g. to look up an encrypted identifier.
+// Anyone seeing two packages learns whether they hold the same message, use a randomized Encryptor for everything else.
+// Use a key dedicated to deterministic encryption.
+func NewDeterministicEncryptor(encyptionKey []byte) (Encryptor, error) {
+	aead, err := NewSIV(encyptionKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptorDeterministic{aead: aead}, nil
+}
+
+// NewDeterministicDecryptor creates a Decryptor for packages sealed by a deterministic Encryptor.
+func NewDeterministicDecryptor(encyptionKey []byte) (Decryptor, error) {
+	aead, err := NewSIV(encyptionKey, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptorDeterministic{aead: aead}, nil
+}
+
+// Crypt deterministically encrypts the given message with the provided additional data.
+func (e encryptorDeterministic) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError("message was nil")
+	}
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+	header := Header{Version: HeaderVersion2, Algorithm: AlgorithmDeterministic, KeyID: e.keyID}
+
+	return sealAEAD(nil, e.aead, header, nil, message, additionalData)
+}
+
+// withKeyID returns a copy of the encryptor stamping id into the header of its packages.
+func (e encryptorDeterministic) withKeyID(id uint32) Encryptor {
+	e.keyID = id
+	return e
+}
+
+// Crypt decrypts the given cipher package.
+func (d decryptorDeterministic) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+
+	return openAEAD(nil, d.aead, AlgorithmDeterministic, cipherpackage, false)
+}
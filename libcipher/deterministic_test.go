@@ -0,0 +1,76 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestDeterministic_EncryptDecrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{0x01}, 32)
+	encryptor, err := libcipher.NewDeterministicEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewDeterministicDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := encryptor.Crypt([]byte("user@example.com"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := encryptor.Crypt([]byte("user@example.com"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected equal packages for equal messages")
+	}
+
+	// Any change of message or additional data changes the whole package.
+	var testCases = []struct {
+		name    string
+		message []byte
+		ad      []byte
+	}{
+		{name: "OtherMessage", message: []byte("user@example.org"), ad: []byte("ad")},
+		{name: "OtherAdditionalData", message: []byte("user@example.com"), ad: []byte("da")},
+		{name: "EmptyMessage", message: []byte{}, ad: []byte("ad")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			other, err := encryptor.Crypt(tc.message, tc.ad)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Equal(first, other) {
+				t.Fatal("expected different packages")
+			}
+			decrypted, ad, err := decryptor.Crypt(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, tc.message) || !bytes.Equal(ad, tc.ad) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+			}
+		})
+	}
+
+	// Deterministic packages are not accepted by the nonce-based AES-SIV decryptor and vice versa.
+	sivDecryptor, err := libcipher.NewSIVDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := sivDecryptor.Crypt(first); err == nil {
+		t.Fatal("expected an error decrypting a deterministic package with AES-SIV")
+	}
+
+	tampered := bytes.Clone(first)
+	tampered[len(tampered)-1] ^= 1
+	if _, _, err := decryptor.Crypt(tampered); err == nil {
+		t.Fatal("expected an error decrypting a tampered package")
+	}
+}
@@ -0,0 +1,253 @@
+package libcipher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// envelopeKEKContext binds the key wrapping the DEK to the header of the package.
+const envelopeKEKContext = "libcipher/envelope/kek"
+
+// encryptorEnvelope seals every message under a fresh data-encryption key (DEK) wrapped by a key-encryption key (KEK).
+type encryptorEnvelope struct {
+	kek       []byte
+	kekID     uint32
+	algorithm string
+	spec      AlgorithmSpec
+	rand      io.Reader
+}
+
+// decryptorEnvelope unwraps the DEK of a package with the KEK named in its header.
+type decryptorEnvelope struct {
+	keks map[uint32][]byte
+}
+
+// NewEnvelopeEncryptor creates an Encryptor using envelope encryption.
+// Every message is sealed by the registered algorithm, e.g. "aes-256-gcm" or "aes-256-cbc-hmac-sha256", under fresh random keys.
+// These data-encryption keys are wrapped with the key-encryption key kek of 16, 24 or 32 bytes using AES-KWP (RFC 5649).
+//
+//	The final encrypted string format:
+//	[ Header | Wrapped-DEK-Length (2 bytes) | Wrapped DEK | Inner Package ]
+//
+// The header carries kekID as key id and the name of the inner algorithm as parameters.
+// The header is authenticated twice: the DEK is wrapped with a key derived from the KEK & the whole header,
+// the inner package carries the header without the KEK id in front of the additional data.
+// Rotating the KEK only requires RewrapEnvelope on every package, the inner packages are left untouched.
+func NewEnvelopeEncryptor(kek []byte, kekID uint32, algorithm string, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	if _, err := newKeyWrapCipher(kek); err != nil {
+		return nil, err
+	}
+	spec, err := LookupAlgorithm(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptorEnvelope{kek: bytes.Clone(kek), kekID: kekID, algorithm: algorithm, spec: spec, rand: rand}, nil
+}
+
+// NewEnvelopeDecryptor creates a Decryptor for envelope packages, keks holds the key-encryption keys by id.
+// Old KEKs can be kept next to the current one until all packages were rewrapped.
+func NewEnvelopeDecryptor(keks map[uint32][]byte) (Decryptor, error) {
+	if len(keks) == 0 {
+		return nil, InvalidUsageError("no key-encryption key given")
+	}
+	copied := make(map[uint32][]byte, len(keks))
+	for id, kek := range keks {
+		if _, err := newKeyWrapCipher(kek); err != nil {
+			return nil, err
+		}
+		copied[id] = bytes.Clone(kek)
+	}
+
+	return decryptorEnvelope{keks: copied}, nil
+}
+
+// Crypt seals the message under a fresh DEK with the provided additional data.
+func (e encryptorEnvelope) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError("message was nil")
+	}
+
+	// Draw the DEKs & seal the message.
+	keys := make([][]byte, len(e.spec.KeySizes))
+	var dek []byte
+	for i, size := range e.spec.KeySizes {
+		keys[i] = make([]byte, size)
+		if _, err := io.ReadFull(e.rand, keys[i]); err != nil {
+			return nil, err
+		}
+		dek = append(dek, keys[i]...)
+	}
+	defer clear(dek)
+	encryptor, err := e.spec.NewEncryptor(keys, e.rand)
+	if err != nil {
+		return nil, err
+	}
+	header := envelopeHeader(e.kekID, e.algorithm)
+	inner, err := encryptor.Crypt(message, append(envelopeBinding(header), additionalData...))
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := wrapEnvelopeKey(e.kek, header, dek)
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleEnvelope(header, wrapped, inner)
+}
+
+// withKeyID returns a copy of the encryptor using id as KEK id.
+func (e encryptorEnvelope) withKeyID(id uint32) Encryptor {
+	e.kekID = id
+	return e
+}
+
+// Crypt unwraps the DEK & decrypts the inner package.
+func (d decryptorEnvelope) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	header, wrapped, inner, err := parseEnvelope(cipherpackage)
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, err := LookupAlgorithm(string(header.Parameters))
+	if err != nil {
+		return nil, nil, err
+	}
+	kek, ok := d.keks[header.KeyID]
+	if !ok {
+		return nil, nil, CipherTextError(fmt.Sprintf("no key-encryption key %d", header.KeyID))
+	}
+	dek, err := unwrapEnvelopeKey(kek, header, wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(dek)
+
+	// Split the DEK into the keys of the inner algorithm.
+	keys := make([][]byte, len(spec.KeySizes))
+	rest := dek
+	for i, size := range spec.KeySizes {
+		if len(rest) < size {
+			return nil, nil, CipherTextError("wrapped key does not match the algorithm")
+		}
+		keys[i], rest = rest[:size], rest[size:]
+	}
+	if len(rest) != 0 {
+		return nil, nil, CipherTextError("wrapped key does not match the algorithm")
+	}
+	decryptor, err := spec.NewDecryptor(keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	message, additionalData, err := decryptor.Crypt(inner)
+	if err != nil {
+		return nil, nil, err
+	}
+	binding := envelopeBinding(header)
+	if !bytes.HasPrefix(additionalData, binding) {
+		return nil, nil, fmt.Errorf("data integrity compromised %w", CipherTextError("envelope header does not match the inner package"))
+	}
+
+	return message, additionalData[len(binding):], nil
+}
+
+// RewrapEnvelope rewraps the DEK of an envelope package with a new KEK, the inner package is left untouched.
+// keks holds the key-encryption keys by id, the one named in the package header is used to unwrap.
+func RewrapEnvelope(cipherpackage []byte, keks map[uint32][]byte, kek []byte, kekID uint32) ([]byte, error) {
+	header, wrapped, inner, err := parseEnvelope(cipherpackage)
+	if err != nil {
+		return nil, err
+	}
+	old, ok := keks[header.KeyID]
+	if !ok {
+		return nil, CipherTextError(fmt.Sprintf("no key-encryption key %d", header.KeyID))
+	}
+	dek, err := unwrapEnvelopeKey(old, header, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dek)
+	rewrappedHeader := envelopeHeader(kekID, string(header.Parameters))
+	rewrapped, err := wrapEnvelopeKey(kek, rewrappedHeader, dek)
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleEnvelope(rewrappedHeader, rewrapped, inner)
+}
+
+// envelopeHeader returns the header of an envelope package.
+func envelopeHeader(kekID uint32, algorithm string) Header {
+	return Header{Version: HeaderVersion2, Algorithm: AlgorithmEnvelope, KeyID: kekID, Parameters: []byte(algorithm)}
+}
+
+// envelopeBinding returns the header the inner package is bound to.
+// The KEK id is left out, RewrapEnvelope changes it without touching the inner package.
+func envelopeBinding(header Header) []byte {
+	header.KeyID = 0
+	return header.append(nil)
+}
+
+// wrapEnvelopeKey wraps the DEK with a key derived from the KEK & the header, AES-KWP has no additional data of its own.
+func wrapEnvelopeKey(kek []byte, header Header, dek []byte) ([]byte, error) {
+	bound, err := DeriveKey(kek, header.append(nil), envelopeKEKContext, len(kek), sha256.New)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(bound)
+
+	return WrapKeyWithPadding(bound, dek)
+}
+
+// unwrapEnvelopeKey unwraps the DEK wrapped by wrapEnvelopeKey, a header changed after sealing derives another key & fails.
+func unwrapEnvelopeKey(kek []byte, header Header, wrapped []byte) ([]byte, error) {
+	bound, err := DeriveKey(kek, header.append(nil), envelopeKEKContext, len(kek), sha256.New)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(bound)
+
+	return UnwrapKeyWithPadding(bound, wrapped)
+}
+
+// assembleEnvelope encodes an envelope package.
+func assembleEnvelope(header Header, wrapped []byte, inner []byte) ([]byte, error) {
+	if len(wrapped) > 65535 {
+		return nil, MessageError("wrapped key too large")
+	}
+	cipherpackage := header.append(make([]byte, 0, headerFixedLength+len(header.Parameters)+2+len(wrapped)+len(inner)))
+	cipherpackage = binary.BigEndian.AppendUint16(cipherpackage, uint16(len(wrapped)))
+	cipherpackage = append(cipherpackage, wrapped...)
+
+	return append(cipherpackage, inner...), nil
+}
+
+// parseEnvelope splits an envelope package into its header, the wrapped DEK and the inner package.
+func parseEnvelope(cipherpackage []byte) (Header, []byte, []byte, error) {
+	header, headerLength, err := ParseHeader(cipherpackage)
+	if err != nil {
+		return Header{}, nil, nil, err
+	}
+	if err := header.check(AlgorithmEnvelope, 0); err != nil {
+		return Header{}, nil, nil, err
+	}
+	body := cipherpackage[headerLength:]
+	if len(body) < 2 {
+		return Header{}, nil, nil, CipherTextError("cipherText is too short")
+	}
+	wrappedLength := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+wrappedLength {
+		return Header{}, nil, nil, CipherTextError("cipherText is too short for the wrapped key")
+	}
+
+	return header, body[2 : 2+wrappedLength], body[2+wrappedLength:], nil
+}
@@ -0,0 +1,167 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestEnvelope_EncryptDecrypt(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x01}, 32)
+	decryptor, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{7: kek})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, algorithm := range []string{"aes-256-gcm", "aes-256-cbc-hmac-sha256"} {
+		t.Run(algorithm, func(t *testing.T) {
+			encryptor, err := libcipher.NewEnvelopeEncryptor(kek, 7, algorithm, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, _, err := libcipher.ParseHeader(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Algorithm != libcipher.AlgorithmEnvelope || header.KeyID != 7 || string(header.Parameters) != algorithm {
+				t.Fatalf("unexpected header %+v", header)
+			}
+			decrypted, ad, err := decryptor.Crypt(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, []byte("message")) || !bytes.Equal(ad, []byte("ad")) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+			}
+
+			tampered := bytes.Clone(cipherpackage)
+			tampered[len(tampered)-1] ^= 1
+			if _, _, err := decryptor.Crypt(tampered); err == nil {
+				t.Fatal("expected an error decrypting a tampered package")
+			}
+		})
+	}
+}
+
+func TestEnvelope_Rewrap(t *testing.T) {
+	oldKEK := bytes.Repeat([]byte{0x01}, 32)
+	newKEK := bytes.Repeat([]byte{0x02}, 32)
+	encryptor, err := libcipher.NewEnvelopeEncryptor(oldKEK, 1, "aes-256-gcm", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrapped, err := libcipher.RewrapEnvelope(cipherpackage, map[uint32][]byte{1: oldKEK}, newKEK, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The inner package is left untouched.
+	if !bytes.HasSuffix(rewrapped, cipherpackage[len(cipherpackage)-32:]) {
+		t.Fatal("expected the inner package to be unchanged")
+	}
+
+	onlyNew, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{2: newKEK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, _, err := onlyNew.Crypt(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, []byte("message")) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+	}
+	if _, _, err := onlyNew.Crypt(cipherpackage); err == nil {
+		t.Fatal("expected an error decrypting a package wrapped by an unknown KEK")
+	}
+
+	// A KEK registered under the wrong id can't unwrap the DEK.
+	wrongID, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{1: newKEK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := wrongID.Crypt(cipherpackage); err == nil {
+		t.Fatal("expected an error unwrapping with the wrong KEK")
+	}
+}
+
+func TestEnvelope_Keyring(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x03}, 32)
+	encryptor, err := libcipher.NewEnvelopeEncryptor(kek, 0, "aes-256-gcm", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{5: kek})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := libcipher.NewKeyring()
+	if err := keyring.Add(5, encryptor, decryptor); err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := keyring.Encryptor().Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, _, err := keyring.Decryptor().Crypt(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, []byte("message")) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+	}
+}
+
+func TestEnvelope_HeaderBinding(t *testing.T) {
+	kek := bytes.Repeat([]byte{0x04}, 32)
+	encryptor, err := libcipher.NewEnvelopeEncryptor(kek, 1, "aes-256-gcm", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("additional data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, headerLength, err := libcipher.ParseHeader(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same KEK is known under both ids, only the header binding tells them apart.
+	decryptor, err := libcipher.NewEnvelopeDecryptor(map[uint32][]byte{1: kek, 2: kek})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases = []struct {
+		name   string
+		modify func(header *libcipher.Header)
+	}{
+		{"Algorithm", func(header *libcipher.Header) { header.Parameters = []byte("xchacha20-poly1305") }},
+		{"KEKID", func(header *libcipher.Header) { header.KeyID = 2 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modified := header
+			tc.modify(&modified)
+			encoded, err := modified.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tampered := append(encoded, cipherpackage[headerLength:]...)
+			_, _, err = decryptor.Crypt(tampered)
+			var cipherTextErr libcipher.CipherTextError
+			if !errors.As(err, &cipherTextErr) {
+				t.Fatalf("expected an authentication error, got %v", err)
+			}
+		})
+	}
+}
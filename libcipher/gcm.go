@@ -3,16 +3,21 @@ package libcipher
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"encoding/binary"
 	"errors"
 	"io"
+	"sync/atomic"
 )
 
 // cryptorGCM implements the Encryptor and Decryptor interfaces using AES-GCM.
 type cryptorGCM struct {
-	gcm       cipher.AEAD
-	rand      io.Reader
-	blocksize func() int
+	gcm        cipher.AEAD
+	algorithm  Algorithm
+	nonces     NonceSource
+	blocksize  func() int
+	keyID      uint32
+	parameters []byte
+	// sealed counts the messages sealed with random nonces, it is shared by all copies of an encryptor.
+	sealed *atomic.Uint64
 }
 
 // Encryption mode.
@@ -22,12 +27,39 @@ type encryptorGCM cryptorGCM
 type decryptorGCM cryptorGCM
 
 // NewGCMEncryptor creates a new Encryptor using AES-GCM with the given key.
+//
+//	The final encrypted string format:
+//	[ Header | Nonce | AD-Lenght | AD | Ciphertext | Authentication Tag ]
+//
+// Everything in front of the ciphertext is passed to GCM as additional data.
+//
+// The nonces are random, the encryptor refuses to seal more than MaxGCMRandomNonceMessages messages.
+// The count is kept in memory per encryptor, NewLimitedEncryptorWithUsage continues the usage of a key after a restart.
 func NewGCMEncryptor(encyptionKey []byte, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
 	cryptor, err := newGCMCryptor(encyptionKey)
 	if err != nil {
 		return nil, err
 	}
-	cryptor.rand = rand
+	cryptor.useRandomNonces(rand)
+
+	return (encryptorGCM)(cryptor), nil
+}
+
+// NewGCMEncryptorWithNonces creates a new Encryptor using AES-GCM drawing its nonces from nonces.
+// With a CounterNonceSource a key can seal far more than the 2^32 messages safe with random nonces.
+// The package format is the same as for NewGCMEncryptor.
+func NewGCMEncryptorWithNonces(encyptionKey []byte, nonces NonceSource) (Encryptor, error) {
+	if nonces == nil {
+		return nil, InvalidUsageError("nonce source was nil")
+	}
+	cryptor, err := newGCMCryptor(encyptionKey)
+	if err != nil {
+		return nil, err
+	}
+	cryptor.nonces = nonces
 
 	return (encryptorGCM)(cryptor), nil
 }
@@ -56,81 +88,152 @@ func newGCMCryptor(encyptionKey []byte) (cryptorGCM, error) {
 	if err != nil {
 		return cryptorGCM{}, err
 	}
-	return cryptorGCM{gcm: gcm, blocksize: block.BlockSize}, nil
+	return cryptorGCM{gcm: gcm, algorithm: AlgorithmGCM, blocksize: block.BlockSize}, nil
+}
+
+// useRandomNonces draws the nonces from rand and limits the encryptor to MaxGCMRandomNonceMessages messages.
+func (c *cryptorGCM) useRandomNonces(rand io.Reader) {
+	c.nonces = NewRandomNonceSource(rand)
+	c.sealed = new(atomic.Uint64)
 }
 
 // Crypt encrypts the given message using AES-GCM with the provided additional data.
 func (e encryptorGCM) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	return e.Seal(nil, message, additionalData)
+}
+
+// Seal encrypts the message like Crypt and appends the package to dst.
+// The nonce is drawn directly into the package, reusing dst avoids allocations.
+// dst must not overlap message or additionalData.
+func (e encryptorGCM) Seal(dst []byte, message []byte, additionalData []byte) ([]byte, error) {
 	if message == nil {
 		return nil, MessageError("message was nil")
 	}
 	if uint64(len(message)) > uint64(((1<<32)-2)*e.blocksize()) {
 		return nil, MessageError("message too large for GCM")
 	}
-	if len(additionalData) > maxAdditionalDataSize {
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
 		return nil, MessageError("additional data too large")
 	}
-
-	nonce := make([]byte, e.gcm.NonceSize())
-	if _, err := io.ReadFull(e.rand, nonce); err != nil {
-		return nil, err
+	// Count before sealing, a failed encryption may have used a nonce.
+	if e.sealed != nil && e.sealed.Add(1) > MaxGCMRandomNonceMessages {
+		return nil, UsageLimitError("key sealed the maximum number of messages with random nonces, rotation needed")
 	}
 
-	// Allocate space for the cipherpackage
-	cipherpackage := make([]byte, len(nonce)+additionalDataHeaderLength+len(additionalData)+len(message)+e.gcm.Overhead())
+	header := Header{Version: HeaderVersion2, Algorithm: e.algorithm, KeyID: e.keyID, Parameters: e.parameters}
+
+	return sealAEAD(dst, e.gcm, header, e.nonces, message, additionalData)
+}
+
+// withKeyID returns a copy of the encryptor stamping id into the header of its packages.
+func (e encryptorGCM) withKeyID(id uint32) Encryptor {
+	e.keyID = id
+	return e
+}
+
+// Crypt decrypts the given cipher package using AES-GCM.
+// Packages sealed before the header was introduced are decrypted with the legacy layout:
+//
+//	[ Nonce | AD-Lenght | AD | Ciphertext | Authentication Tag ]
+func (d decryptorGCM) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	return d.Open(nil, cipherpackage)
+}
+
+// Open decrypts the given cipher package like Crypt and appends the message to dst.
+// The returned additional data is a slice of the package, reusing dst avoids allocations.
+func (d decryptorGCM) Open(dst []byte, cipherpackage []byte) ([]byte, []byte, error) {
+	// Legacy packages predate the committing variant.
+	return openAEAD(dst, d.gcm, d.algorithm, cipherpackage, d.algorithm == AlgorithmGCM)
+}
+
+// sealAEAD assembles the package of an AEAD cryptor and appends it to dst.
+//
+//	[ Header | Nonce | AD-Lenght | AD | Ciphertext | Authentication Tag ]
+//
+// The nonce is drawn from nonces, which is unused for AEADs without nonce.
+// Everything in front of the ciphertext is passed to the AEAD as additional data.
+func sealAEAD(dst []byte, aead cipher.AEAD, header Header, nonces NonceSource, message []byte, additionalData []byte) ([]byte, error) {
+	headerLength := headerFixedLength + len(header.Parameters)
+	adLengthSize := additionalDataLengthSize(header.Version)
+	nonceSize := aead.NonceSize()
+	// Extend dst to hold the cipherpackage
+	ret, cipherpackage := sliceForAppend(dst, headerLength+nonceSize+adLengthSize+len(additionalData)+len(message)+aead.Overhead())
 
 	// Define locations
-	nonceLocation := 0
-	adHeaderHeaderLocation := nonceLocation + len(nonce)
-	adHeaderLocation := adHeaderHeaderLocation + additionalDataHeaderLength
+	nonceLocation := headerLength
+	adHeaderHeaderLocation := nonceLocation + nonceSize
+	adHeaderLocation := adHeaderHeaderLocation + adLengthSize
 	dataLocation := adHeaderLocation + len(additionalData)
 
-	// Copy nonce to the beginning of the cipherpackage
-	copy(cipherpackage[nonceLocation:adHeaderHeaderLocation], nonce)
+	// Copy header to the beginning of the cipherpackage & draw the nonce behind it
+	header.append(cipherpackage[:0])
+	nonce := cipherpackage[nonceLocation:adHeaderHeaderLocation]
+	if nonceSize > 0 {
+		if err := nonces.Nonce(nonce); err != nil {
+			return nil, err
+		}
+	}
 
 	// Copy additional data length and additional data into cipherpackage
-	binary.BigEndian.PutUint16(cipherpackage[adHeaderHeaderLocation:adHeaderLocation], uint16(len(additionalData)))
+	putAdditionalDataLength(cipherpackage[adHeaderHeaderLocation:adHeaderLocation], header.Version, len(additionalData))
 	copy(cipherpackage[adHeaderLocation:dataLocation], additionalData)
 
-	// Encrypt the message
-	e.gcm.Seal(cipherpackage[dataLocation:dataLocation], nonce, message, additionalData)
+	// Encrypt the message, everything in front of the ciphertext is authenticated.
+	aead.Seal(cipherpackage[dataLocation:dataLocation], nonce, message, cipherpackage[:dataLocation])
 
-	return cipherpackage, nil
+	return ret, nil
 }
 
-// Crypt decrypts the given cipher package using AES-GCM.
-func (d decryptorGCM) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
-	nonceSize := d.gcm.NonceSize()
-	const additionalDataHeaderLength = 2
+// openAEAD decrypts the package of an AEAD cryptor sealed with the given algorithm and appends the message to dst.
+// Headerless packages are only accepted if legacy is set, they only authenticate the additional data.
+func openAEAD(dst []byte, aead cipher.AEAD, algorithm Algorithm, cipherpackage []byte, legacy bool) ([]byte, []byte, error) {
+	nonceSize := aead.NonceSize()
 
-	if len(cipherpackage) < nonceSize+additionalDataHeaderLength {
+	header, headerLength, err := ParseHeader(cipherpackage)
+	if err != nil && !legacy {
+		return nil, nil, err
+	}
+	legacy = err != nil
+	if legacy {
+		header.Version = HeaderVersion1
+	} else if err := header.check(algorithm, 0); err != nil {
+		return nil, nil, err
+	}
+	adLengthSize := additionalDataLengthSize(header.Version)
+
+	if len(cipherpackage) < headerLength+nonceSize+adLengthSize {
 		return nil, nil, errors.New("cipherpackage too short")
 	}
 
 	// Define locations
-	nonceLocation := 0
+	nonceLocation := headerLength
 	adHeaderHeaderLocation := nonceLocation + nonceSize
-	adHeaderLocation := adHeaderHeaderLocation + additionalDataHeaderLength
-	dataLocation := adHeaderLocation + int(binary.BigEndian.Uint16(cipherpackage[adHeaderHeaderLocation:adHeaderLocation]))
+	adHeaderLocation := adHeaderHeaderLocation + adLengthSize
 
-	if len(cipherpackage) < dataLocation {
+	// Extract the additional data length
+	adLength := additionalDataLength(cipherpackage[adHeaderHeaderLocation:adHeaderLocation], header.Version)
+	if adLength > uint64(len(cipherpackage)-adHeaderLocation) {
 		return nil, nil, errors.New("cipherpackage too short for additional data")
 	}
+	dataLocation := adHeaderLocation + int(adLength)
 
 	// Extract the nonce
 	nonce := cipherpackage[nonceLocation:adHeaderHeaderLocation]
 
-	// Extract the additional data length
-	additionalDataLength := binary.BigEndian.Uint16(cipherpackage[adHeaderHeaderLocation:adHeaderLocation])
-
 	// Extract the additional data
-	additionalData := cipherpackage[adHeaderLocation : adHeaderLocation+int(additionalDataLength)]
+	additionalData := cipherpackage[adHeaderLocation:dataLocation]
 
 	// Extract the ciphertext
-	ciphertext := cipherpackage[adHeaderLocation+int(additionalDataLength):]
+	ciphertext := cipherpackage[dataLocation:]
+
+	// Legacy packages only authenticate the additional data.
+	authenticated := cipherpackage[:dataLocation]
+	if legacy {
+		authenticated = additionalData
+	}
 
 	// Decrypt the ciphertext
-	plaintext, err := d.gcm.Open(nil, nonce, ciphertext, additionalData)
+	plaintext, err := aead.Open(dst, nonce, ciphertext, authenticated)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,241 @@
+package libcipher
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/binary"
+	"hash"
+)
+
+// Algorithm identifies the construction a cipher package was sealed with.
+type Algorithm byte
+
+const (
+	AlgorithmCBCHMAC       Algorithm = 1
+	AlgorithmGCM           Algorithm = 2
+	AlgorithmCBCHMACStream Algorithm = 3
+	AlgorithmGCMStream     Algorithm = 4
+	AlgorithmXChaCha20     Algorithm = 5
+	AlgorithmAESSIV        Algorithm = 6
+	AlgorithmDeterministic Algorithm = 7
+	AlgorithmEnvelope      Algorithm = 8
+	AlgorithmX25519        Algorithm = 9
+	AlgorithmMulti         Algorithm = 10
+	AlgorithmEd25519       Algorithm = 11
+	AlgorithmCommittingGCM Algorithm = 12
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgorithmCBCHMAC:
+		return "aes-cbc-hmac"
+	case AlgorithmGCM:
+		return "aes-gcm"
+	case AlgorithmCBCHMACStream:
+		return "aes-cbc-hmac-stream"
+	case AlgorithmGCMStream:
+		return "aes-gcm-stream"
+	case AlgorithmXChaCha20:
+		return "xchacha20-poly1305"
+	case AlgorithmAESSIV:
+		return "aes-siv"
+	case AlgorithmDeterministic:
+		return "aes-siv-deterministic"
+	case AlgorithmEnvelope:
+		return "envelope"
+	case AlgorithmX25519:
+		return "x25519-chacha20-poly1305"
+	case AlgorithmMulti:
+		return "multi-recipient"
+	case AlgorithmEd25519:
+		return "ed25519-signed"
+	case AlgorithmCommittingGCM:
+		return "aes-gcm-committing"
+	}
+
+	return "unknown"
+}
+
+// Versions of the package header, the version also defines the layout of the package following the header.
+const (
+	// HeaderVersion1 packages encode the additional data length in 2 bytes, they are only read.
+	// Streams are still sealed with version 1.
+	HeaderVersion1 byte = 1
+	// HeaderVersion2 packages encode the additional data length in 4 bytes.
+	HeaderVersion2 byte = 2
+)
+
+// headerMagic marks the start of every package sealed with a header.
+var headerMagic = []byte("U8CP")
+
+// Size of the fixed part of the header.
+// Magic | Version | Algorithm | Hash | Key ID | Parameters-Length
+const headerFixedLength = 4 + 1 + 1 + 1 + 4 + 2
+
+// Header is the self-describing prefix of every cipher package produced by libcipher.
+//
+//	The header format:
+//	[ Magic "U8CP" | Version | Algorithm | Hash | Key ID (4 bytes) | Parameters-Length (2 bytes) | Parameters ]
+//
+// The header is always authenticated together with the package, either by the MAC or as part of the AEAD additional data.
+// Parsing a header does not authenticate it, don't act on its content before the package was decrypted.
+type Header struct {
+	Version   byte
+	Algorithm Algorithm
+	// Hash used for the integrity of the package, 0 if the algorithm does not need one or the hash is unknown.
+	Hash crypto.Hash
+	// KeyID identifies the key the package was sealed with, 0 if no key id was assigned.
+	KeyID uint32
+	// Parameters are algorithm specific, empty for the AES modes.
+	Parameters []byte
+}
+
+// MarshalBinary encodes the header.
+func (h Header) MarshalBinary() ([]byte, error) {
+	if len(h.Parameters) > 65535 {
+		return nil, InvalidUsageError("header parameters too large")
+	}
+
+	return h.append(nil), nil
+}
+
+// append encodes the header to dst, parameters must not exceed 65535 bytes.
+func (h Header) append(dst []byte) []byte {
+	dst = append(dst, headerMagic...)
+	dst = append(dst, h.Version, byte(h.Algorithm), byte(h.Hash))
+	dst = binary.BigEndian.AppendUint32(dst, h.KeyID)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(h.Parameters)))
+
+	return append(dst, h.Parameters...)
+}
+
+// HasHeader reports whether the package starts with a header.
+// Packages sealed before headers were introduced don't have one.
+func HasHeader(cipherpackage []byte) bool {
+	return bytes.HasPrefix(cipherpackage, headerMagic)
+}
+
+// ParseHeader decodes the header of a cipher package.
+// It returns the header, its encoded length and an error if the package has no valid header.
+func ParseHeader(cipherpackage []byte) (Header, int, error) {
+	if !HasHeader(cipherpackage) {
+		return Header{}, 0, CipherTextError("package has no header")
+	}
+	if len(cipherpackage) < headerFixedLength {
+		return Header{}, 0, CipherTextError("package header too short")
+	}
+	h := Header{
+		Version:   cipherpackage[4],
+		Algorithm: Algorithm(cipherpackage[5]),
+		Hash:      crypto.Hash(cipherpackage[6]),
+		KeyID:     binary.BigEndian.Uint32(cipherpackage[7:11]),
+	}
+	if h.Version != HeaderVersion1 && h.Version != HeaderVersion2 {
+		return Header{}, 0, CipherTextError("unsupported package version")
+	}
+	length := headerFixedLength + int(binary.BigEndian.Uint16(cipherpackage[11:13]))
+	if len(cipherpackage) < length {
+		return Header{}, 0, CipherTextError("package header too short")
+	}
+	if length > headerFixedLength {
+		h.Parameters = cipherpackage[headerFixedLength:length]
+	}
+
+	return h, length, nil
+}
+
+// check validates a parsed header against the expectations of a cryptor.
+func (h Header) check(algorithm Algorithm, hash crypto.Hash) error {
+	if h.Algorithm != algorithm {
+		return CipherTextError("package was sealed with " + h.Algorithm.String() + " not " + algorithm.String())
+	}
+	if h.Hash != 0 && hash != 0 && h.Hash != hash {
+		return CipherTextError("package was sealed with " + h.Hash.String() + " not " + hash.String())
+	}
+
+	return nil
+}
+
+// additionalDataLengthSize returns the size of the additional data length in packages of the given version.
+// Headerless legacy packages use the layout of version 1.
+func additionalDataLengthSize(version byte) int {
+	if version == HeaderVersion1 {
+		return 2
+	}
+
+	return 4
+}
+
+// putAdditionalDataLength encodes the additional data length n for the given package version into dst.
+func putAdditionalDataLength(dst []byte, version byte, n int) {
+	if version == HeaderVersion1 {
+		binary.BigEndian.PutUint16(dst, uint16(n))
+		return
+	}
+	binary.BigEndian.PutUint32(dst, uint32(n))
+}
+
+// additionalDataLength decodes the additional data length of the given package version from src.
+func additionalDataLength(src []byte, version byte) uint64 {
+	if version == HeaderVersion1 {
+		return uint64(binary.BigEndian.Uint16(src))
+	}
+
+	return uint64(binary.BigEndian.Uint32(src))
+}
+
+// knownHashes are the hashes identifyHash is able to recognize.
+var knownHashes = []crypto.Hash{crypto.SHA1, crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA512_224, crypto.SHA512_256}
+
+// identifyHash finds the crypto.Hash behind a hash constructor by comparing the digests of an empty message.
+// It returns 0 if the hash is unknown.
+func identifyHash(calculateMAC func() hash.Hash) crypto.Hash {
+	digest := calculateMAC().Sum(nil)
+	for _, known := range knownHashes {
+		if known.Size() == len(digest) && bytes.Equal(known.New().Sum(nil), digest) {
+			return known
+		}
+	}
+
+	return 0
+}
+
+// NewPackageDecryptor creates a Decryptor that dispatches every package to the Decryptor for the algorithm in its header.
+// Packages without a header are handed to legacy, which may be nil if headerless packages must be rejected.
+func NewPackageDecryptor(decryptors map[Algorithm]Decryptor, legacy Decryptor) Decryptor {
+	dispatch := make(map[Algorithm]Decryptor, len(decryptors))
+	for algorithm, decryptor := range decryptors {
+		dispatch[algorithm] = decryptor
+	}
+
+	return packageDecryptor{decryptors: dispatch, legacy: legacy}
+}
+
+// Dispatching decryptor.
+type packageDecryptor struct {
+	decryptors map[Algorithm]Decryptor
+	legacy     Decryptor
+}
+
+// Crypt decrypts the given cipher package with the Decryptor matching its header.
+func (d packageDecryptor) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	header, _, err := ParseHeader(cipherpackage)
+	if err != nil {
+		if d.legacy == nil {
+			return nil, nil, err
+		}
+		return d.legacy.Crypt(cipherpackage)
+	}
+	decryptor, ok := d.decryptors[header.Algorithm]
+	if !ok {
+		return nil, nil, CipherTextError("no decryptor for " + header.Algorithm.String())
+	}
+
+	return decryptor.Crypt(cipherpackage)
+}
@@ -0,0 +1,320 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestHeader_Parse(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	encryptor, err := libcipher.NewCBCHMACEncryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, length, err := libcipher.ParseHeader(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Algorithm != libcipher.AlgorithmCBCHMAC || header.Version != libcipher.HeaderVersion2 || header.Hash.String() != "SHA-256" {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	encoded, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, cipherpackage[:length]) {
+		t.Fatalf("header encoding mismatch: %x : %x", encoded, cipherpackage[:length])
+	}
+}
+
+func TestHeader_AlgorithmMismatch(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	cipherpackage, err := testEncryptGCM(t, encryptionKey, nil, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = testDecryptCBC(t, encryptionKey, integrityKey, cipherpackage)
+	if err == nil || !strings.Contains(err.Error(), "sealed with aes-gcm") {
+		t.Fatalf("expected an algorithm mismatch, got %v", err)
+	}
+}
+
+func TestHeader_Tampered(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	cbc, err := testEncryptCBC(t, encryptionKey, integrityKey, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := testEncryptGCM(t, encryptionKey, nil, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Change the key id.
+	cbc[8] ^= 1
+	gcm[8] ^= 1
+	if _, err := testDecryptCBC(t, encryptionKey, integrityKey, cbc); err == nil {
+		t.Fatal("expected an error decrypting a package with tampered header")
+	}
+	if _, err := testDecryptGCM(t, encryptionKey, nil, gcm); err == nil {
+		t.Fatal("expected an error decrypting a package with tampered header")
+	}
+}
+
+func TestHeader_Legacy(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	message := []byte("This is some super secret data to encrypt.")
+	additionalData := []byte("2024-05-01")
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// [ MAC | AD-Lenght | AD | Initialization Vector | Block 1 | Block 2 | ... ]
+	padding := aes.BlockSize - len(message)%aes.BlockSize
+	payload := append(append([]byte{}, message...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	signed := binary.BigEndian.AppendUint16(nil, uint16(len(additionalData)))
+	signed = append(append(signed, additionalData...), iv...)
+	ciphertext := make([]byte, len(payload))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, payload)
+	signed = append(signed, ciphertext...)
+	mac := hmac.New(sha256.New, integrityKey)
+	mac.Write(signed)
+	legacyCBC := append(mac.Sum(nil), signed...)
+
+	// [ Nonce | AD-Lenght | AD | Ciphertext | Authentication Tag ]
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	legacyGCM := binary.BigEndian.AppendUint16(append([]byte{}, nonce...), uint16(len(additionalData)))
+	legacyGCM = append(legacyGCM, additionalData...)
+	legacyGCM = gcm.Seal(legacyGCM, nonce, message, additionalData)
+
+	cbcDecryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmDecryptor, err := libcipher.NewGCMDecryptor(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		name          string
+		decryptor     libcipher.Decryptor
+		cipherpackage []byte
+	}{
+		{name: "CBCHMAC", decryptor: cbcDecryptor, cipherpackage: legacyCBC},
+		{name: "GCM", decryptor: gcmDecryptor, cipherpackage: legacyGCM},
+		{name: "DispatchLegacy", decryptor: libcipher.NewPackageDecryptor(nil, cbcDecryptor), cipherpackage: legacyCBC},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decrypted, ad, err := tc.decryptor.Crypt(tc.cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, message) || !bytes.Equal(ad, additionalData) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s : %s", decrypted, message)
+			}
+		})
+	}
+}
+
+func TestHeader_Version1(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	message := []byte("This is some super secret data to encrypt.")
+	additionalData := []byte("2024-05-01")
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// [ Header | MAC | AD-Lenght (2 bytes) | AD | Initialization Vector | Block 1 | Block 2 | ... ]
+	cbcHeader, err := libcipher.Header{Version: libcipher.HeaderVersion1, Algorithm: libcipher.AlgorithmCBCHMAC, Hash: crypto.SHA256}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := aes.BlockSize - len(message)%aes.BlockSize
+	payload := append(append([]byte{}, message...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	signed := binary.BigEndian.AppendUint16(nil, uint16(len(additionalData)))
+	signed = append(append(signed, additionalData...), iv...)
+	ciphertext := make([]byte, len(payload))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, payload)
+	signed = append(signed, ciphertext...)
+	mac := hmac.New(sha256.New, integrityKey)
+	mac.Write(cbcHeader)
+	mac.Write(signed)
+	version1CBC := append(append(cbcHeader, mac.Sum(nil)...), signed...)
+
+	// [ Header | Nonce | AD-Lenght (2 bytes) | AD | Ciphertext | Authentication Tag ]
+	gcmHeader, err := libcipher.Header{Version: libcipher.HeaderVersion1, Algorithm: libcipher.AlgorithmGCM}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	version1GCM := binary.BigEndian.AppendUint16(append(gcmHeader, nonce...), uint16(len(additionalData)))
+	version1GCM = append(version1GCM, additionalData...)
+	version1GCM = gcm.Seal(version1GCM, nonce, message, version1GCM)
+
+	cbcDecryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmDecryptor, err := libcipher.NewGCMDecryptor(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		name          string
+		decryptor     libcipher.Decryptor
+		cipherpackage []byte
+	}{
+		{name: "CBCHMAC", decryptor: cbcDecryptor, cipherpackage: version1CBC},
+		{name: "GCM", decryptor: gcmDecryptor, cipherpackage: version1GCM},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decrypted, ad, err := tc.decryptor.Crypt(tc.cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, message) || !bytes.Equal(ad, additionalData) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s : %s", decrypted, message)
+			}
+		})
+	}
+}
+
+func TestHeader_LargeAdditionalData(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	message := []byte("message")
+	additionalData := bytes.Repeat([]byte("claims"), 100000)
+
+	cbcEncryptor, err := libcipher.NewCBCHMACEncryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbcDecryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmEncryptor, err := libcipher.NewGCMEncryptor(encryptionKey, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmDecryptor, err := libcipher.NewGCMDecryptor(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		name      string
+		encryptor libcipher.Encryptor
+		decryptor libcipher.Decryptor
+	}{
+		{name: "CBCHMAC", encryptor: cbcEncryptor, decryptor: cbcDecryptor},
+		{name: "GCM", encryptor: gcmEncryptor, decryptor: gcmDecryptor},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cipherpackage, err := tc.encryptor.Crypt(message, additionalData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, ad, err := tc.decryptor.Crypt(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, message) || !bytes.Equal(ad, additionalData) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+			}
+			// Truncating the additional data must not go unnoticed.
+			header, length, err := libcipher.ParseHeader(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Version != libcipher.HeaderVersion2 {
+				t.Fatalf("unexpected version %d", header.Version)
+			}
+			if _, _, err := tc.decryptor.Crypt(cipherpackage[:length+100]); err == nil {
+				t.Fatal("expected an error decrypting a truncated package")
+			}
+		})
+	}
+}
+
+func TestHeader_PackageDecryptor(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	message := []byte("message")
+	cbc, err := testEncryptCBC(t, encryptionKey, integrityKey, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := testEncryptGCM(t, encryptionKey, nil, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cbcDecryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcmDecryptor, err := libcipher.NewGCMDecryptor(encryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor := libcipher.NewPackageDecryptor(map[libcipher.Algorithm]libcipher.Decryptor{
+		libcipher.AlgorithmCBCHMAC: cbcDecryptor,
+		libcipher.AlgorithmGCM:     gcmDecryptor,
+	}, nil)
+	for _, cipherpackage := range [][]byte{cbc, gcm} {
+		decrypted, _, err := decryptor.Crypt(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, message) {
+			t.Fatalf("Decrypted data doesn't match original plaintext: %s : %s", decrypted, message)
+		}
+	}
+	if _, _, err := decryptor.Crypt(message); err == nil {
+		t.Fatal("expected an error decrypting a headerless package without legacy decryptor")
+	}
+}
@@ -0,0 +1,472 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+// updateKAT regenerates the golden vectors, run `go test -run TestKnownAnswers -update` after an intended format change only.
+// A changed vector is a wire-format break, packages sealed by released versions must still open after it.
+var updateKAT = flag.Bool("update", false, "regenerate the known-answer vectors in testdata")
+
+const katPath = "testdata/kat.json"
+
+// knownAnswer is a golden vector, the package has to be reproduced from the keys & the random bytes.
+type knownAnswer struct {
+	Name           string   `json:"name"`
+	Keys           []string `json:"keys"`
+	Rand           string   `json:"rand"`
+	Message        string   `json:"message"`
+	AdditionalData string   `json:"additional_data"`
+	Package        string   `json:"package"`
+}
+
+// katCryptor seals & opens the packages of a vector.
+type katCryptor struct {
+	keySizes []int
+	seal     func(keys [][]byte, rand io.Reader, message []byte, additionalData []byte) ([]byte, error)
+	open     func(keys [][]byte, cipherpackage []byte) ([]byte, []byte, error)
+}
+
+// katEncryptor adapts constructors of an Encryptor & Decryptor to a katCryptor.
+func katEncryptor(keySizes []int, newEncryptor func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error), newDecryptor func(keys [][]byte) (libcipher.Decryptor, error)) katCryptor {
+	return katCryptor{
+		keySizes: keySizes,
+		seal: func(keys [][]byte, rand io.Reader, message []byte, additionalData []byte) ([]byte, error) {
+			encryptor, err := newEncryptor(keys, rand)
+			if err != nil {
+				return nil, err
+			}
+			return encryptor.Crypt(message, additionalData)
+		},
+		open: func(keys [][]byte, cipherpackage []byte) ([]byte, []byte, error) {
+			decryptor, err := newDecryptor(keys)
+			if err != nil {
+				return nil, nil, err
+			}
+			return decryptor.Crypt(cipherpackage)
+		},
+	}
+}
+
+// katPasswordParams keeps the password vectors fast.
+var katPasswordParams = libcipher.PasswordParams{KDF: libcipher.KDFScrypt, Cost: 1 << 10, Memory: 8, Parallelism: 1}
+
+// katCryptors returns the cryptors covered by golden vectors, every registered algorithm and every other construction.
+func katCryptors(t *testing.T) map[string]katCryptor {
+	cryptors := make(map[string]katCryptor)
+	for _, name := range libcipher.Algorithms() {
+		spec, err := libcipher.LookupAlgorithm(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cryptors[name] = katEncryptor(spec.KeySizes, spec.NewEncryptor, spec.NewDecryptor)
+	}
+
+	cryptors["aes-256-cbc-hmac-sha256-stream"] = katCryptor{
+		keySizes: []int{32, 32},
+		seal: func(keys [][]byte, rand io.Reader, message []byte, additionalData []byte) ([]byte, error) {
+			var sealed bytes.Buffer
+			writer, err := libcipher.NewCBCHMACStreamWriterWithRand(&sealed, keys[0], keys[1], sha256.New, rand, additionalData)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := writer.Write(message); err != nil {
+				return nil, err
+			}
+			if err := writer.Close(); err != nil {
+				return nil, err
+			}
+			return sealed.Bytes(), nil
+		},
+		open: func(keys [][]byte, cipherpackage []byte) ([]byte, []byte, error) {
+			reader, additionalData, err := libcipher.NewCBCHMACStreamReader(bytes.NewReader(cipherpackage), keys[0], keys[1], sha256.New)
+			if err != nil {
+				return nil, nil, err
+			}
+			message, err := io.ReadAll(reader)
+			return message, additionalData, err
+		},
+	}
+	cryptors["aes-256-gcm-stream"] = katCryptor{
+		keySizes: []int{32},
+		seal: func(keys [][]byte, rand io.Reader, message []byte, additionalData []byte) ([]byte, error) {
+			var sealed bytes.Buffer
+			writer, err := libcipher.NewGCMStreamWriter(&sealed, keys[0], rand, additionalData)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := writer.Write(message); err != nil {
+				return nil, err
+			}
+			if err := writer.Close(); err != nil {
+				return nil, err
+			}
+			return sealed.Bytes(), nil
+		},
+		open: func(keys [][]byte, cipherpackage []byte) ([]byte, []byte, error) {
+			reader, additionalData, err := libcipher.NewGCMStreamReader(bytes.NewReader(cipherpackage), keys[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			message, err := io.ReadAll(reader)
+			return message, additionalData, err
+		},
+	}
+	cryptors["aes-256-cbc-hmac-sha256-aead"] = katCryptor{
+		keySizes: []int{32, 32},
+		seal: func(keys [][]byte, rand io.Reader, message []byte, additionalData []byte) ([]byte, error) {
+			aead, err := libcipher.NewCBCHMACAEAD(keys[0], keys[1], sha256.New)
+			if err != nil {
+				return nil, err
+			}
+			nonce := make([]byte, aead.NonceSize())
+			if _, err := io.ReadFull(rand, nonce); err != nil {
+				return nil, err
+			}
+			return aead.Seal(nonce, nonce, message, additionalData), nil
+		},
+		open: func(keys [][]byte, cipherpackage []byte) ([]byte, []byte, error) {
+			aead, err := libcipher.NewCBCHMACAEAD(keys[0], keys[1], sha256.New)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(cipherpackage) < aead.NonceSize() {
+				return nil, nil, libcipher.CipherTextError("cipherText is too short")
+			}
+			// The additional data isn't part of the output of an AEAD, the vector fixes it.
+			additionalData := []byte("known answer additional data")
+			message, err := aead.Open(nil, cipherpackage[:aead.NonceSize()], cipherpackage[aead.NonceSize():], additionalData)
+			return message, additionalData, err
+		},
+	}
+	cryptors["x25519-chacha20-poly1305"] = katEncryptor([]int{32},
+		func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error) {
+			identity, err := libcipher.GenerateX25519Identity(bytes.NewReader(keys[0]))
+			if err != nil {
+				return nil, err
+			}
+			return libcipher.NewX25519Encryptor(identity.PublicKey(), rand)
+		},
+		func(keys [][]byte) (libcipher.Decryptor, error) {
+			identity, err := libcipher.GenerateX25519Identity(bytes.NewReader(keys[0]))
+			if err != nil {
+				return nil, err
+			}
+			return libcipher.NewX25519Decryptor(identity)
+		})
+	cryptors["envelope-aes-256-gcm"] = katEncryptor([]int{32},
+		func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error) {
+			return libcipher.NewEnvelopeEncryptor(keys[0], 1, "aes-256-gcm", rand)
+		},
+		func(keys [][]byte) (libcipher.Decryptor, error) {
+			return libcipher.NewEnvelopeDecryptor(map[uint32][]byte{1: keys[0]})
+		})
+	cryptors["multi-recipient-symmetric"] = katEncryptor([]int{32},
+		func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error) {
+			recipient, err := libcipher.NewSymmetricRecipient(1, keys[0])
+			if err != nil {
+				return nil, err
+			}
+			return libcipher.NewMultiRecipientEncryptor([]libcipher.Recipient{recipient}, rand)
+		},
+		func(keys [][]byte) (libcipher.Decryptor, error) {
+			identity, err := libcipher.NewSymmetricRecipient(1, keys[0])
+			if err != nil {
+				return nil, err
+			}
+			return libcipher.NewMultiRecipientDecryptor(identity)
+		})
+	cryptors["ed25519-signed-xchacha20-poly1305"] = katEncryptor([]int{32, ed25519.SeedSize},
+		func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error) {
+			encryptor, err := libcipher.NewXChaChaEncryptor(keys[0], rand)
+			if err != nil {
+				return nil, err
+			}
+			return libcipher.NewSigner(encryptor, 1, ed25519.NewKeyFromSeed(keys[1]))
+		},
+		func(keys [][]byte) (libcipher.Decryptor, error) {
+			decryptor, err := libcipher.NewXChaChaDecryptor(keys[0])
+			if err != nil {
+				return nil, err
+			}
+			publicKey := ed25519.NewKeyFromSeed(keys[1]).Public().(ed25519.PublicKey)
+			return libcipher.NewVerifier(decryptor, map[uint32]ed25519.PublicKey{1: publicKey})
+		})
+	for _, algorithm := range []libcipher.Algorithm{libcipher.AlgorithmCBCHMAC, libcipher.AlgorithmGCM} {
+		cryptors["password-scrypt-"+algorithm.String()] = katEncryptor([]int{16},
+			func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error) {
+				return libcipher.NewPasswordEncryptorWithRand(keys[0], katPasswordParams, algorithm, rand)
+			},
+			func(keys [][]byte) (libcipher.Decryptor, error) {
+				return libcipher.NewPasswordDecryptor(keys[0])
+			})
+	}
+
+	return cryptors
+}
+
+// katStream is a deterministic byte stream, SHA-256 of the seed & a counter.
+type katStream struct {
+	seed    string
+	counter uint64
+	block   []byte
+}
+
+func (s *katStream) Read(p []byte) (int, error) {
+	for n := 0; n < len(p); {
+		if len(s.block) == 0 {
+			h := sha256.New()
+			h.Write([]byte(s.seed))
+			h.Write(binary.BigEndian.AppendUint64(nil, s.counter))
+			s.block = h.Sum(nil)
+			s.counter++
+		}
+		copied := copy(p[n:], s.block)
+		s.block = s.block[copied:]
+		n += copied
+	}
+
+	return len(p), nil
+}
+
+// generateKnownAnswer seals a new vector with keys & random bytes drawn from a stream seeded by the name.
+func generateKnownAnswer(name string, cryptor katCryptor) (knownAnswer, error) {
+	stream := &katStream{seed: "libcipher/kat/" + name}
+	vector := knownAnswer{Name: name, Message: "known answer message", AdditionalData: "known answer additional data"}
+	keys := make([][]byte, len(cryptor.keySizes))
+	for i, size := range cryptor.keySizes {
+		keys[i] = make([]byte, size)
+		if _, err := io.ReadFull(stream, keys[i]); err != nil {
+			return knownAnswer{}, err
+		}
+		vector.Keys = append(vector.Keys, hex.EncodeToString(keys[i]))
+	}
+	var consumed bytes.Buffer
+	cipherpackage, err := cryptor.seal(keys, io.TeeReader(stream, &consumed), []byte(vector.Message), []byte(vector.AdditionalData))
+	if err != nil {
+		return knownAnswer{}, err
+	}
+	vector.Rand = hex.EncodeToString(consumed.Bytes())
+	vector.Package = hex.EncodeToString(cipherpackage)
+
+	return vector, nil
+}
+
+func TestKnownAnswers(t *testing.T) {
+	cryptors := katCryptors(t)
+	if *updateKAT {
+		names := make([]string, 0, len(cryptors))
+		for name := range cryptors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		vectors := make([]knownAnswer, 0, len(names))
+		for _, name := range names {
+			vector, err := generateKnownAnswer(name, cryptors[name])
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			vectors = append(vectors, vector)
+		}
+		encoded, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(katPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(katPath, append(encoded, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encoded, err := os.ReadFile(katPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []knownAnswer
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	covered := make(map[string]bool)
+	for _, vector := range vectors {
+		t.Run(vector.Name, func(t *testing.T) {
+			covered[vector.Name] = true
+			cryptor, ok := cryptors[vector.Name]
+			if !ok {
+				t.Fatal("no cryptor for the vector")
+			}
+			keys := make([][]byte, len(vector.Keys))
+			for i := range vector.Keys {
+				keys[i] = decodeHex(t, vector.Keys[i])
+			}
+			expected := decodeHex(t, vector.Package)
+
+			// Sealing with the recorded random bytes reproduces the package & consumes them all.
+			rand := bytes.NewReader(decodeHex(t, vector.Rand))
+			cipherpackage, err := cryptor.seal(keys, rand, []byte(vector.Message), []byte(vector.AdditionalData))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(cipherpackage, expected) {
+				t.Fatalf("package format changed:\n got %x\nwant %x", cipherpackage, expected)
+			}
+			if rand.Len() != 0 {
+				t.Fatalf("%d random bytes left, the use of randomness changed", rand.Len())
+			}
+
+			// The golden package still decrypts.
+			message, additionalData, err := cryptor.open(keys, expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(message) != vector.Message || string(additionalData) != vector.AdditionalData {
+				t.Fatalf("decrypted %q with %q", message, additionalData)
+			}
+		})
+	}
+	for name := range cryptors {
+		if !covered[name] {
+			t.Errorf("no known-answer vector for %s, run go test -run TestKnownAnswers -update", name)
+		}
+	}
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decoded
+}
+
+func TestNilRand(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	identity, err := libcipher.GenerateX25519Identity(bytes.NewReader(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := libcipher.NewSymmetricRecipient(1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := libcipher.NewMultiRecipientEncryptor([]libcipher.Recipient{recipient}, bytes.NewReader(bytes.Repeat([]byte{3}, 1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	multiPackage, err := multi.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := libcipher.NewSymmetricRecipient(2, bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases = []struct {
+		name        string
+		constructor func() error
+	}{
+		{"CBCHMAC", func() error {
+			_, err := libcipher.NewCBCHMACEncryptorWithRand(key, bytes.Repeat([]byte{2}, 32), sha256.New, nil)
+			return err
+		}},
+		{"CBCHMACStream", func() error {
+			_, err := libcipher.NewCBCHMACStreamWriterWithRand(io.Discard, key, bytes.Repeat([]byte{2}, 32), sha256.New, nil, nil)
+			return err
+		}},
+		{"GCM", func() error {
+			_, err := libcipher.NewGCMEncryptor(key, nil)
+			return err
+		}},
+		{"GCMStream", func() error {
+			_, err := libcipher.NewGCMStreamWriter(io.Discard, key, nil, nil)
+			return err
+		}},
+		{"CommittingGCM", func() error {
+			_, err := libcipher.NewCommittingGCMEncryptor(key, nil)
+			return err
+		}},
+		{"XChaCha", func() error {
+			_, err := libcipher.NewXChaChaEncryptor(key, nil)
+			return err
+		}},
+		{"SIV", func() error {
+			_, err := libcipher.NewSIVEncryptor(bytes.Repeat([]byte{1}, 64), nil)
+			return err
+		}},
+		{"X25519", func() error {
+			_, err := libcipher.NewX25519Encryptor(identity.PublicKey(), nil)
+			return err
+		}},
+		{"X25519Identity", func() error {
+			_, err := libcipher.GenerateX25519Identity(nil)
+			return err
+		}},
+		{"MultiRecipient", func() error {
+			_, err := libcipher.NewMultiRecipientEncryptor([]libcipher.Recipient{recipient}, nil)
+			return err
+		}},
+		{"AddRecipient", func() error {
+			_, err := libcipher.AddRecipient(multiPackage, recipient, other, nil)
+			return err
+		}},
+		{"Envelope", func() error {
+			_, err := libcipher.NewEnvelopeEncryptor(key, 1, "aes-256-gcm", nil)
+			return err
+		}},
+		{"Password", func() error {
+			_, err := libcipher.NewPasswordEncryptorWithRand([]byte("password"), katPasswordParams, libcipher.AlgorithmGCM, nil)
+			return err
+		}},
+		{"Registry", func() error {
+			_, err := libcipher.NewEncryptorWithRand("aes-256-gcm", nil, key)
+			return err
+		}},
+		{"KeyFile", func() error {
+			_, err := libcipher.GenerateKeyFile(libcipher.KeyAlgorithmMaster, 1, "", nil)
+			return err
+		}},
+		{"GenerateKey", func() error {
+			_, err := libcipher.GenerateKeyWithRand(32, nil)
+			return err
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.constructor()
+			if err == nil || !strings.HasSuffix(err.Error(), "rand was nil") {
+				t.Fatalf("expected a nil rand to be refused, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGenerateKeyWithRand(t *testing.T) {
+	key, err := libcipher.GenerateKeyWithRand(4, bytes.NewReader([]byte{0xde, 0xad, 0xbe, 0xef}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "deadbeef" {
+		t.Fatalf("got %s", key)
+	}
+	if _, err := libcipher.GenerateKeyWithRand(5, bytes.NewReader([]byte{0xde, 0xad, 0xbe, 0xef})); err == nil {
+		t.Fatal("accepted a short read")
+	}
+}
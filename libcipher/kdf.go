@@ -0,0 +1,66 @@
+package libcipher
+
+import (
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+type (
+	KeyDerivationError string
+)
+
+func (e KeyDerivationError) Error() string {
+	return "libcipher/cipher: " + (string)(e)
+}
+
+// Context labels separating the subkeys derived by DeriveCBCHMACKeys.
+const (
+	encryptionKeyLabel = "/encryption"
+	integrityKeyLabel  = "/integrity"
+)
+
+// DeriveKey derives a subkey of the given length from a master secret using HKDF (RFC 5869).
+//
+// The salt is optional but recommended, it does not need to be secret.
+// The context binds the subkey to its purpose, different contexts yield independent keys.
+// hashing is typically sha256.New or sha512.New.
+func DeriveKey(masterKey []byte, salt []byte, context string, length int, hashing func() hash.Hash) ([]byte, error) {
+	const minKeySize = 16
+
+	if len(masterKey) < minKeySize {
+		return nil, KeyDerivationError("master key too short")
+	}
+	if len(context) == 0 {
+		return nil, KeyDerivationError("context must not be empty")
+	}
+	if length <= 0 || length > 255*hashing().Size() {
+		return nil, KeyDerivationError("invalid subkey length")
+	}
+
+	key := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(hashing, masterKey, salt, []byte(context)), key); err != nil {
+		return nil, fmt.Errorf("%w:%w", KeyDerivationError("error deriving key"), err)
+	}
+
+	return key, nil
+}
+
+// DeriveCBCHMACKeys derives independent encryption & integrity keys for the AES-CBC+HMAC cryptor from one master secret.
+// The encryption key is 32 bytes (AES-256), the integrity key has the size of the hash output.
+//
+// Use this instead of slicing a key by hand, slices of one key are not independent if the key is hex encoded or otherwise structured.
+func DeriveCBCHMACKeys(masterKey []byte, salt []byte, context string, hashing func() hash.Hash) ([]byte, []byte, error) {
+	encryptionKey, err := DeriveKey(masterKey, salt, context+encryptionKeyLabel, 32, hashing)
+	if err != nil {
+		return nil, nil, err
+	}
+	integrityKey, err := DeriveKey(masterKey, salt, context+integrityKeyLabel, hashing().Size(), hashing)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encryptionKey, integrityKey, nil
+}
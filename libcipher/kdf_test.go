@@ -0,0 +1,63 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestDeriveKey_RFC5869(t *testing.T) {
+	// RFC 5869 A.1. Test Case 1
+	masterKey, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	context, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	expected, _ := hex.DecodeString("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")
+
+	key, err := libcipher.DeriveKey(masterKey, salt, string(context), len(expected), sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, expected) {
+		t.Fatalf("expected %x got %x", expected, key)
+	}
+}
+
+func TestDeriveCBCHMACKeys(t *testing.T) {
+	masterKey := []byte("mysecretmasterkey12345671234abcd")
+	encryptionKey, integrityKey, err := libcipher.DeriveCBCHMACKeys(masterKey, nil, "test", sha512.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encryptionKey) != 32 || len(integrityKey) != sha512.Size {
+		t.Fatalf("unexpected key sizes %d %d", len(encryptionKey), len(integrityKey))
+	}
+	if bytes.Equal(encryptionKey, integrityKey[:32]) {
+		t.Fatal("subkeys must be independent")
+	}
+	otherEncryptionKey, _, err := libcipher.DeriveCBCHMACKeys(masterKey, nil, "other", sha512.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(encryptionKey, otherEncryptionKey) {
+		t.Fatal("different contexts must yield different keys")
+	}
+	if _, _, err := libcipher.DeriveCBCHMACKeys([]byte("too_short"), nil, "test", sha512.New); err == nil {
+		t.Fatal("expected an error for a short master key")
+	}
+
+	ciphertext, err := testEncryptCBC(t, encryptionKey, integrityKey, []byte("message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := testDecryptCBC(t, encryptionKey, integrityKey, ciphertext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, []byte("message")) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+	}
+}
@@ -0,0 +1,418 @@
+package libcipher
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	KeyFileError string
+)
+
+func (e KeyFileError) Error() string {
+	return "libcipher/cipher: " + (string)(e)
+}
+
+// Algorithms of key files besides the names of the algorithm registry.
+const (
+	// KeyAlgorithmMaster marks a master key, the keys of an algorithm are derived from it, e.g. by DeriveAlgorithmKeys.
+	KeyAlgorithmMaster = "master"
+	// KeyAlgorithmX25519 marks an X25519 identity.
+	KeyAlgorithmX25519 = "x25519"
+)
+
+// Lines enclosing a key file.
+const (
+	keyFileBegin = "-----BEGIN U8 KEY-----"
+	keyFileEnd   = "-----END U8 KEY-----"
+)
+
+// Names of the key file headers.
+const (
+	keyFileAlgorithmHeader = "Algorithm"
+	keyFileKeyIDHeader     = "Key-ID"
+	keyFileCreatedHeader   = "Created"
+	keyFilePurposeHeader   = "Purpose"
+)
+
+const (
+	// keyFileMode keeps key files readable by their owner only.
+	keyFileMode = 0600
+	// keyFileChecksumSize is the size of the truncated SHA-256 checksum.
+	keyFileChecksumSize = 4
+	// minMasterKeySize is the smallest master key, as for DeriveKey.
+	minMasterKeySize = 16
+	// defaultMasterKeySize is the size of generated master keys.
+	defaultMasterKeySize = 64
+)
+
+// KeyFile is a key together with the metadata needed to use it.
+//
+//	The key file format:
+//	-----BEGIN U8 KEY-----
+//	Algorithm: aes-256-gcm
+//	Key-ID: 1
+//	Created: 2024-05-01T12:00:00Z
+//	Purpose: files store
+//
+//	<base64 key material, wrapped at 64 characters>
+//	=<base64 checksum>
+//	-----END U8 KEY-----
+//
+// The algorithm is a name of the algorithm registry, KeyAlgorithmMaster or KeyAlgorithmX25519.
+// The material of a registered algorithm is the concatenation of its keys in the order of its key sizes.
+// The checksum is the truncated SHA-256 of the headers & the material, it detects typos & corruption, not tampering.
+type KeyFile struct {
+	Algorithm string
+	// KeyID is stamped into the packages of the Encryptor of the key file.
+	KeyID   uint32
+	Created time.Time
+	// Purpose describes what the key is used for, it has to fit on one line.
+	Purpose  string
+	Material []byte
+}
+
+// GenerateKeyFile creates a key file with random material of the size the algorithm needs.
+// Master keys are 64 bytes, X25519 identities are generated by GenerateX25519Identity.
+func GenerateKeyFile(algorithm string, keyID uint32, purpose string, rand io.Reader) (*KeyFile, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	key := &KeyFile{Algorithm: algorithm, KeyID: keyID, Created: time.Now().UTC().Truncate(time.Second), Purpose: purpose}
+	switch algorithm {
+	case KeyAlgorithmMaster:
+		key.Material = make([]byte, defaultMasterKeySize)
+		if _, err := io.ReadFull(rand, key.Material); err != nil {
+			return nil, fmt.Errorf("%w:%w", KeyGenerationError("error generating key"), err)
+		}
+	case KeyAlgorithmX25519:
+		identity, err := GenerateX25519Identity(rand)
+		if err != nil {
+			return nil, err
+		}
+		key.Material = identity.Bytes()
+	default:
+		spec, err := LookupAlgorithm(algorithm)
+		if err != nil {
+			return nil, err
+		}
+		size := 0
+		for _, keySize := range spec.KeySizes {
+			size += keySize
+		}
+		key.Material = make([]byte, size)
+		if _, err := io.ReadFull(rand, key.Material); err != nil {
+			return nil, fmt.Errorf("%w:%w", KeyGenerationError("error generating key"), err)
+		}
+	}
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// IsKeyFile reports whether data contains the begin line of a key file.
+func IsKeyFile(data []byte) bool {
+	return bytes.Contains(data, []byte(keyFileBegin))
+}
+
+// MarshalText encodes the key file, the result is secret.
+func (k *KeyFile) MarshalText() ([]byte, error) {
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+
+	var encoded bytes.Buffer
+	encoded.WriteString(keyFileBegin + "\n")
+	fmt.Fprintf(&encoded, "%s: %s\n", keyFileAlgorithmHeader, k.Algorithm)
+	fmt.Fprintf(&encoded, "%s: %d\n", keyFileKeyIDHeader, k.KeyID)
+	fmt.Fprintf(&encoded, "%s: %s\n", keyFileCreatedHeader, k.Created.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&encoded, "%s: %s\n", keyFilePurposeHeader, k.Purpose)
+	encoded.WriteString("\n")
+
+	material := base64.StdEncoding.EncodeToString(k.Material)
+	for len(material) > armorLineLength {
+		encoded.WriteString(material[:armorLineLength] + "\n")
+		material = material[armorLineLength:]
+	}
+	if len(material) > 0 {
+		encoded.WriteString(material + "\n")
+	}
+	encoded.WriteString("=" + base64.StdEncoding.EncodeToString(k.checksum()) + "\n")
+	encoded.WriteString(keyFileEnd + "\n")
+
+	return encoded.Bytes(), nil
+}
+
+// ParseKeyFile decodes a key file encoded by MarshalText.
+// Text around the key, indentation and CRLF line endings are ignored, the checksum has to match.
+func ParseKeyFile(data []byte) (*KeyFile, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	begin := -1
+	for i, line := range lines {
+		if line == keyFileBegin {
+			begin = i
+			break
+		}
+	}
+	if begin < 0 {
+		return nil, KeyFileError("begin line not found")
+	}
+	lines = lines[begin+1:]
+
+	// Headers are terminated by an empty line.
+	headers := make(map[string]string)
+	for len(lines) > 0 && len(lines[0]) != 0 {
+		name, value, ok := strings.Cut(lines[0], ":")
+		if !ok {
+			return nil, KeyFileError("missing empty line after the headers")
+		}
+		if _, duplicate := headers[name]; duplicate {
+			return nil, KeyFileError(fmt.Sprintf("duplicate header %s", name))
+		}
+		headers[name] = strings.TrimSpace(value)
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		return nil, KeyFileError("missing empty line after the headers")
+	}
+	lines = lines[1:]
+
+	// Base64 lines up to the checksum & the end line.
+	var encoded strings.Builder
+	var checksum string
+	end := false
+	for _, line := range lines {
+		if line == keyFileEnd {
+			end = true
+			break
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if len(checksum) != 0 {
+			return nil, KeyFileError("unexpected data after the checksum")
+		}
+		if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+			continue
+		}
+		encoded.WriteString(line)
+	}
+	if !end {
+		return nil, KeyFileError("end line not found")
+	}
+	if len(checksum) == 0 {
+		return nil, KeyFileError("missing checksum")
+	}
+
+	key := &KeyFile{Algorithm: headers[keyFileAlgorithmHeader], Purpose: headers[keyFilePurposeHeader]}
+	for _, name := range []string{keyFileAlgorithmHeader, keyFileKeyIDHeader, keyFileCreatedHeader} {
+		if _, ok := headers[name]; !ok {
+			return nil, KeyFileError(fmt.Sprintf("missing header %s", name))
+		}
+	}
+	keyID, err := strconv.ParseUint(headers[keyFileKeyIDHeader], 10, 32)
+	if err != nil {
+		return nil, KeyFileError(fmt.Sprintf("invalid key id %s", headers[keyFileKeyIDHeader]))
+	}
+	key.KeyID = uint32(keyID)
+	key.Created, err = time.Parse(time.RFC3339, headers[keyFileCreatedHeader])
+	if err != nil {
+		return nil, KeyFileError(fmt.Sprintf("invalid creation time %s", headers[keyFileCreatedHeader]))
+	}
+	key.Material, err = base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", KeyFileError("invalid base64"), err)
+	}
+	expected, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || subtle.ConstantTimeCompare(expected, key.checksum()) != 1 {
+		clear(key.Material)
+		return nil, KeyFileError("checksum mismatch, the key file was modified")
+	}
+	if err := key.validate(); err != nil {
+		clear(key.Material)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// LoadKeyFile reads & parses the key file at path.
+func LoadKeyFile(path string) (*KeyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	return ParseKeyFile(data)
+}
+
+// Save writes the key file to path, readable by its owner only.
+// An existing file is never overwritten.
+func (k *KeyFile) Save(path string) error {
+	encoded, err := k.MarshalText()
+	if err != nil {
+		return err
+	}
+	defer clear(encoded)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, keyFileMode)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return KeyFileError(fmt.Sprintf("%s already exists", path))
+		}
+		return err
+	}
+	if _, err := f.Write(encoded); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Keys splits the material of a registered algorithm into its keys.
+func (k *KeyFile) Keys() ([][]byte, error) {
+	spec, err := k.spec()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, len(spec.KeySizes))
+	material := k.Material
+	for i, size := range spec.KeySizes {
+		keys[i], material = material[:size:size], material[size:]
+	}
+
+	return keys, nil
+}
+
+// NewEncryptor creates an Encryptor for the registered algorithm of the key file.
+// The key id is stamped into the packages if the algorithm supports it.
+func (k *KeyFile) NewEncryptor() (Encryptor, error) {
+	keys, err := k.Keys()
+	if err != nil {
+		return nil, err
+	}
+	encryptor, err := NewEncryptor(k.Algorithm, keys...)
+	if err != nil {
+		return nil, err
+	}
+	if identifiable, ok := encryptor.(keyIdentifiable); ok {
+		return identifiable.withKeyID(k.KeyID), nil
+	}
+
+	return encryptor, nil
+}
+
+// NewDecryptor creates a Decryptor for the registered algorithm of the key file.
+func (k *KeyFile) NewDecryptor() (Decryptor, error) {
+	keys, err := k.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDecryptor(k.Algorithm, keys...)
+}
+
+// MasterKey returns the material of a master key file.
+func (k *KeyFile) MasterKey() ([]byte, error) {
+	if k.Algorithm != KeyAlgorithmMaster {
+		return nil, KeyFileError(fmt.Sprintf("%s key is not a master key", k.Algorithm))
+	}
+
+	return k.Material, nil
+}
+
+// Identity returns the X25519 identity of an x25519 key file.
+func (k *KeyFile) Identity() (*ecdh.PrivateKey, error) {
+	if k.Algorithm != KeyAlgorithmX25519 {
+		return nil, KeyFileError(fmt.Sprintf("%s key is not an x25519 identity", k.Algorithm))
+	}
+
+	return ecdh.X25519().NewPrivateKey(k.Material)
+}
+
+// validate checks the headers and the size of the material against the algorithm.
+func (k *KeyFile) validate() error {
+	if strings.ContainsAny(k.Purpose, "\r\n") {
+		return KeyFileError("purpose must fit on one line")
+	}
+	if k.Purpose != strings.TrimSpace(k.Purpose) {
+		return KeyFileError("purpose must not start or end with whitespace")
+	}
+	switch k.Algorithm {
+	case KeyAlgorithmMaster:
+		if len(k.Material) < minMasterKeySize {
+			return KeyFileError(fmt.Sprintf("master key must be at least %d bytes", minMasterKeySize))
+		}
+	case KeyAlgorithmX25519:
+		if len(k.Material) != 32 {
+			return KeyFileError("x25519 identity must be 32 bytes")
+		}
+	default:
+		if _, err := k.spec(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// spec returns the spec of the registered algorithm after checking the size of the material.
+func (k *KeyFile) spec() (AlgorithmSpec, error) {
+	if k.Algorithm == KeyAlgorithmMaster || k.Algorithm == KeyAlgorithmX25519 {
+		return AlgorithmSpec{}, KeyFileError(fmt.Sprintf("%s key is not for a registered algorithm", k.Algorithm))
+	}
+	spec, err := LookupAlgorithm(k.Algorithm)
+	if err != nil {
+		return AlgorithmSpec{}, err
+	}
+	size := 0
+	for _, keySize := range spec.KeySizes {
+		size += keySize
+	}
+	if len(k.Material) != size {
+		return AlgorithmSpec{}, KeyFileError(fmt.Sprintf("%s key material must be %d bytes", k.Algorithm, size))
+	}
+
+	return spec, nil
+}
+
+// checksum returns the truncated SHA-256 of the headers & the material, every field is length prefixed.
+func (k *KeyFile) checksum() []byte {
+	h := sha256.New()
+	for _, field := range [][]byte{
+		[]byte(k.Algorithm),
+		binary.BigEndian.AppendUint32(nil, k.KeyID),
+		[]byte(k.Created.UTC().Format(time.RFC3339)),
+		[]byte(k.Purpose),
+		k.Material,
+	} {
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(field))))
+		h.Write(field)
+	}
+
+	return h.Sum(nil)[:keyFileChecksumSize]
+}
@@ -0,0 +1,184 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestKeyFileRoundTrip(t *testing.T) {
+	for _, algorithm := range append([]string{libcipher.KeyAlgorithmMaster, libcipher.KeyAlgorithmX25519}, libcipher.Algorithms()...) {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := libcipher.GenerateKeyFile(algorithm, 42, "files store", rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := key.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !libcipher.IsKeyFile(encoded) {
+				t.Fatal("encoded key file not detected")
+			}
+			parsed, err := libcipher.ParseKeyFile(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.Algorithm != algorithm || parsed.KeyID != 42 || parsed.Purpose != "files store" || !parsed.Created.Equal(key.Created) || !bytes.Equal(parsed.Material, key.Material) {
+				t.Fatalf("parsed %+v, want %+v", parsed, key)
+			}
+		})
+	}
+}
+
+func TestKeyFileCryptors(t *testing.T) {
+	key, err := libcipher.GenerateKeyFile("aes-256-gcm", 7, "", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := key.NewEncryptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := key.NewDecryptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("additional data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, err := libcipher.ParseHeader(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.KeyID != 7 {
+		t.Fatalf("package carries key id %d", header.KeyID)
+	}
+	message, _, err := decryptor.Crypt(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != "message" {
+		t.Fatalf("decrypted %q", message)
+	}
+
+	// A master key or an identity has no cryptors of its own.
+	master, err := libcipher.GenerateKeyFile(libcipher.KeyAlgorithmMaster, 1, "", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := master.NewEncryptor(); err == nil {
+		t.Fatal("created an encryptor from a master key")
+	}
+	if _, err := master.Identity(); err == nil {
+		t.Fatal("returned an identity of a master key")
+	}
+	if _, err := key.MasterKey(); err == nil {
+		t.Fatal("returned the material of an aes-256-gcm key as master key")
+	}
+}
+
+func TestKeyFileSave(t *testing.T) {
+	key, err := libcipher.GenerateKeyFile(libcipher.KeyAlgorithmMaster, 1, "backup", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := key.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("key file has mode %v", info.Mode().Perm())
+	}
+	loaded, err := libcipher.LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Material, key.Material) {
+		t.Fatal("loaded material doesn't match")
+	}
+	if err := key.Save(path); err == nil {
+		t.Fatal("overwrote an existing key file")
+	}
+}
+
+func TestParseKeyFile(t *testing.T) {
+	key := &libcipher.KeyFile{
+		Algorithm: libcipher.KeyAlgorithmMaster,
+		KeyID:     3,
+		Created:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Purpose:   "tests",
+		Material:  bytes.Repeat([]byte{0x5a}, 32),
+	}
+	encoded, err := key.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := string(encoded)
+
+	tests := []struct {
+		name     string
+		encoded  string
+		expected string
+	}{
+		{"Surrounded", "key of the test store:\r\n" + strings.ReplaceAll("  "+strings.ReplaceAll(valid, "\n", "\n  "), "\n", "\r\n"), ""},
+		{"NoBegin", strings.ReplaceAll(valid, "-----BEGIN U8 KEY-----", ""), "libcipher/cipher: begin line not found"},
+		{"NoEnd", strings.ReplaceAll(valid, "-----END U8 KEY-----", ""), "libcipher/cipher: end line not found"},
+		{"ChangedPurpose", strings.Replace(valid, "Purpose: tests", "Purpose: prod", 1), "libcipher/cipher: checksum mismatch, the key file was modified"},
+		{"ChangedKeyID", strings.Replace(valid, "Key-ID: 3", "Key-ID: 4", 1), "libcipher/cipher: checksum mismatch, the key file was modified"},
+		{"MissingKeyID", strings.Replace(valid, "Key-ID: 3\n", "", 1), "libcipher/cipher: missing header Key-ID"},
+		{"DuplicateHeader", strings.Replace(valid, "Key-ID: 3\n", "Key-ID: 3\nKey-ID: 4\n", 1), "libcipher/cipher: duplicate header Key-ID"},
+		{"InvalidCreated", strings.Replace(valid, "2024-05-01T12:00:00Z", "yesterday", 1), "libcipher/cipher: invalid creation time yesterday"},
+		{"MissingChecksum", valid[:strings.Index(valid, "\n=")+1] + "-----END U8 KEY-----\n", "libcipher/cipher: missing checksum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := libcipher.ParseKeyFile([]byte(tt.encoded))
+			if len(tt.expected) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if parsed.KeyID != 3 || parsed.Purpose != "tests" || !bytes.Equal(parsed.Material, key.Material) {
+					t.Fatalf("parsed %+v", parsed)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestKeyFileValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      libcipher.KeyFile
+		expected string
+	}{
+		{"ShortMaster", libcipher.KeyFile{Algorithm: libcipher.KeyAlgorithmMaster, Material: make([]byte, 8)}, "libcipher/cipher: master key must be at least 16 bytes"},
+		{"ShortIdentity", libcipher.KeyFile{Algorithm: libcipher.KeyAlgorithmX25519, Material: make([]byte, 16)}, "libcipher/cipher: x25519 identity must be 32 bytes"},
+		{"AlgorithmSize", libcipher.KeyFile{Algorithm: "aes-256-gcm", Material: make([]byte, 16)}, "libcipher/cipher: aes-256-gcm key material must be 32 bytes"},
+		{"UnknownAlgorithm", libcipher.KeyFile{Algorithm: "rot13", Material: make([]byte, 16)}, "libcipher/cipher: unknown algorithm rot13"},
+		{"MultilinePurpose", libcipher.KeyFile{Algorithm: libcipher.KeyAlgorithmMaster, Purpose: "a\nb", Material: make([]byte, 16)}, "libcipher/cipher: purpose must fit on one line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.key.MarshalText()
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"io"
 )
 
 type (
@@ -16,12 +17,20 @@ func (e KeyGenerationError) Error() string {
 
 // GenerateKey generates a cryptographically random key with the specified length.
 func GenerateKey(keyLength int) (string, error) {
+	return GenerateKeyWithRand(keyLength, rand.Reader)
+}
+
+// GenerateKeyWithRand is GenerateKey reading the key from rand.
+func GenerateKeyWithRand(keyLength int, rand io.Reader) (string, error) {
 	if keyLength <= 0 {
 		return "", KeyGenerationError("key length must be positive")
 	}
+	if rand == nil {
+		return "", KeyGenerationError("rand was nil")
+	}
 
 	key := make([]byte, keyLength)
-	if _, err := rand.Read(key); err != nil {
+	if _, err := io.ReadFull(rand, key); err != nil {
 		return "", fmt.Errorf("%w:%w", KeyGenerationError("error generating key"), err)
 	}
 	encodedKey := hex.EncodeToString(key)
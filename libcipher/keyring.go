@@ -0,0 +1,176 @@
+package libcipher
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Keyring holds multiple versioned keys to allow reading old and new packages side by side during a key rotation.
+//
+// Every key is registered under an id together with its Encryptor and Decryptor.
+// Packages are sealed with the active key and carry its id in their header,
+// the keyring Decryptor picks the key by the id found in the header.
+//
+// Packages without a header, sealed before headers were introduced, are decrypted with the key registered under id 0.
+//
+// Rotation:
+//
+//	Add the new key, make it active and keep the old keys until all packages sealed with them were re-encrypted.
+//	Remove a key only after that, packages sealed with a removed key can't be decrypted anymore.
+type Keyring struct {
+	mu         sync.RWMutex
+	active     uint32
+	hasActive  bool
+	encryptors map[uint32]Encryptor
+	decryptors map[uint32]Decryptor
+}
+
+// NewKeyring creates an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{
+		encryptors: make(map[uint32]Encryptor),
+		decryptors: make(map[uint32]Decryptor),
+	}
+}
+
+// keyIdentifiable is implemented by the Encryptors which can stamp a key id into their packages.
+type keyIdentifiable interface {
+	withKeyID(id uint32) Encryptor
+}
+
+// stampKeyID returns a copy of encryptor stamping id into its packages, false if it can't stamp key ids.
+// A LimitedEncryptor only can if the encryptor it wraps can, its copy shares the usage.
+func stampKeyID(encryptor Encryptor, id uint32) (Encryptor, bool) {
+	switch e := encryptor.(type) {
+	case *LimitedEncryptor:
+		stamped, ok := stampKeyID(e.encryptor, id)
+		if !ok {
+			return nil, false
+		}
+		return &LimitedEncryptor{encryptor: stamped, limits: e.limits, state: e.state}, true
+	case keyIdentifiable:
+		return e.withKeyID(id), true
+	}
+
+	return nil, false
+}
+
+// Add registers the cryptors of a key under id.
+// The encryptor may be nil for keys which are only kept to read old packages.
+// It has to be created by this package, as the key id is stamped into the authenticated header of its packages.
+// The first key with an encryptor becomes the active key.
+func (k *Keyring) Add(id uint32, encryptor Encryptor, decryptor Decryptor) error {
+	if decryptor == nil {
+		return InvalidUsageError("decryptor was nil")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.decryptors[id]; ok {
+		return InvalidUsageError(fmt.Sprintf("key %d already exists", id))
+	}
+	if encryptor != nil {
+		stamped, ok := stampKeyID(encryptor, id)
+		if !ok {
+			return InvalidUsageError("encryptor does not support key ids")
+		}
+		k.encryptors[id] = stamped
+		if !k.hasActive {
+			k.active, k.hasActive = id, true
+		}
+	}
+	k.decryptors[id] = decryptor
+
+	return nil
+}
+
+// SetActive selects the key used for all further encryptions.
+func (k *Keyring) SetActive(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.encryptors[id]; !ok {
+		return InvalidUsageError(fmt.Sprintf("key %d has no encryptor", id))
+	}
+	k.active, k.hasActive = id, true
+
+	return nil
+}
+
+// Active returns the id of the active key.
+func (k *Keyring) Active() (uint32, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.active, k.hasActive
+}
+
+// Remove drops the key with the given id, the active key can't be removed.
+func (k *Keyring) Remove(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.hasActive && k.active == id {
+		return InvalidUsageError(fmt.Sprintf("key %d is active", id))
+	}
+	if _, ok := k.decryptors[id]; !ok {
+		return InvalidUsageError(fmt.Sprintf("key %d does not exist", id))
+	}
+	delete(k.encryptors, id)
+	delete(k.decryptors, id)
+
+	return nil
+}
+
+// Encryptor returns an Encryptor sealing with whatever key is active at the time of the call to Crypt.
+func (k *Keyring) Encryptor() Encryptor {
+	return keyringEncryptor{keyring: k}
+}
+
+// Decryptor returns a Decryptor selecting the key by the id in the package header.
+func (k *Keyring) Decryptor() Decryptor {
+	return keyringDecryptor{keyring: k}
+}
+
+// Encryption mode of the keyring.
+type keyringEncryptor struct {
+	keyring *Keyring
+}
+
+// Crypt encrypts the message with the active key.
+func (e keyringEncryptor) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	e.keyring.mu.RLock()
+	encryptor, ok := e.keyring.encryptors[e.keyring.active]
+	ok = ok && e.keyring.hasActive
+	e.keyring.mu.RUnlock()
+	if !ok {
+		return nil, EncryptionKeyError("keyring has no active key")
+	}
+
+	return encryptor.Crypt(message, additionalData)
+}
+
+// Decryption mode of the keyring.
+type keyringDecryptor struct {
+	keyring *Keyring
+}
+
+// Crypt decrypts the package with the key referenced in its header.
+func (d keyringDecryptor) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	// Headerless packages are sealed with the legacy key 0.
+	var id uint32
+	if header, _, err := ParseHeader(cipherpackage); err == nil {
+		id = header.KeyID
+	}
+	d.keyring.mu.RLock()
+	decryptor, ok := d.keyring.decryptors[id]
+	d.keyring.mu.RUnlock()
+	if !ok {
+		return nil, nil, EncryptionKeyError(fmt.Sprintf("key %d not found in keyring", id))
+	}
+
+	return decryptor.Crypt(cipherpackage)
+}
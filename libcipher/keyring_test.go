@@ -0,0 +1,100 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestKeyring_Rotation(t *testing.T) {
+	keyring := libcipher.NewKeyring()
+	oldEncryptor, err := libcipher.NewCBCHMACEncryptor([]byte("mysecretencryptionkey12345671234"), []byte("anothersecretintegritykey12345671234"), sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDecryptor, err := libcipher.NewCBCHMACDecryptor([]byte("mysecretencryptionkey12345671234"), []byte("anothersecretintegritykey12345671234"), sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(1, oldEncryptor, oldDecryptor); err != nil {
+		t.Fatal(err)
+	}
+	encryptor, decryptor := keyring.Encryptor(), keyring.Decryptor()
+	oldPackage, err := encryptor.Crypt([]byte("old"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to a new key.
+	newEncryptor, err := libcipher.NewGCMEncryptor([]byte("thenewencryptionkey1234567891234"), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDecryptor, err := libcipher.NewGCMDecryptor([]byte("thenewencryptionkey1234567891234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(2, newEncryptor, newDecryptor); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetActive(2); err != nil {
+		t.Fatal(err)
+	}
+	newPackage, err := encryptor.Crypt([]byte("new"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, tc := range map[uint32]struct {
+		cipherpackage []byte
+		plaintext     []byte
+	}{1: {oldPackage, []byte("old")}, 2: {newPackage, []byte("new")}} {
+		header, _, err := libcipher.ParseHeader(tc.cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.KeyID != id {
+			t.Fatalf("expected key id %d got %d", id, header.KeyID)
+		}
+		decrypted, _, err := decryptor.Crypt(tc.cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, tc.plaintext) {
+			t.Fatalf("Decrypted data doesn't match original plaintext: %s : %s", decrypted, tc.plaintext)
+		}
+	}
+
+	if err := keyring.Remove(2); err == nil {
+		t.Fatal("expected an error removing the active key")
+	}
+	if err := keyring.Remove(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := decryptor.Crypt(oldPackage); err == nil {
+		t.Fatal("expected an error decrypting a package of a removed key")
+	}
+}
+
+func TestKeyring_Errors(t *testing.T) {
+	keyring := libcipher.NewKeyring()
+	if _, err := keyring.Encryptor().Crypt([]byte("message"), nil); err == nil {
+		t.Fatal("expected an error encrypting without an active key")
+	}
+	decryptor, err := libcipher.NewGCMDecryptor([]byte("mysecretencryptionkey12345671234"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(1, nil, decryptor); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(1, nil, decryptor); err == nil {
+		t.Fatal("expected an error adding a key twice")
+	}
+	if err := keyring.SetActive(1); err == nil {
+		t.Fatal("expected an error activating a key without encryptor")
+	}
+}
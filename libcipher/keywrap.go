@@ -0,0 +1,171 @@
+package libcipher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+)
+
+// Initial values of AES Key Wrap.
+var (
+	// keyWrapIV is the default initial value of RFC 3394.
+	keyWrapIV = [8]byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+	// keyWrapPaddingIV is the constant half of the alternative initial value of RFC 5649.
+	keyWrapPaddingIV = [4]byte{0xa6, 0x59, 0x59, 0xa6}
+)
+
+// WrapKey wraps key with the key-encryption key kek using AES Key Wrap (RFC 3394).
+// The key has to be a multiple of 8 bytes and at least 16 bytes long, use WrapKeyWithPadding for any other length.
+func WrapKey(kek []byte, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, InvalidUsageError("key to wrap must be a multiple of 8 bytes and at least 16 bytes")
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrap(block, keyWrapIV, key), nil
+}
+
+// UnwrapKey unwraps a key wrapped by WrapKey.
+func UnwrapKey(kek []byte, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, CipherTextError("wrapped key is invalid")
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	iv, key := unwrap(block, wrapped)
+	if subtle.ConstantTimeCompare(iv[:], keyWrapIV[:]) != 1 {
+		clear(key)
+		return nil, CipherTextError("key unwrap failed")
+	}
+
+	return key, nil
+}
+
+// WrapKeyWithPadding wraps a key of any length between 1 and 2^32-1 bytes using AES Key Wrap with Padding (RFC 5649).
+func WrapKeyWithPadding(kek []byte, key []byte) ([]byte, error) {
+	if len(key) == 0 || uint64(len(key)) > 1<<32-1 {
+		return nil, InvalidUsageError("key to wrap must be between 1 and 2^32-1 bytes")
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	var iv [8]byte
+	copy(iv[:], keyWrapPaddingIV[:])
+	binary.BigEndian.PutUint32(iv[4:], uint32(len(key)))
+	padded := make([]byte, (len(key)+7)/8*8)
+	copy(padded, key)
+
+	// A single block is encrypted directly.
+	if len(padded) == 8 {
+		wrapped := make([]byte, aes.BlockSize)
+		copy(wrapped, iv[:])
+		copy(wrapped[8:], padded)
+		block.Encrypt(wrapped, wrapped)
+		return wrapped, nil
+	}
+
+	return wrap(block, iv, padded), nil
+}
+
+// UnwrapKeyWithPadding unwraps a key wrapped by WrapKeyWithPadding.
+func UnwrapKeyWithPadding(kek []byte, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 16 || len(wrapped)%8 != 0 {
+		return nil, CipherTextError("wrapped key is invalid")
+	}
+	block, err := newKeyWrapCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	var iv [8]byte
+	var padded []byte
+	if len(wrapped) == aes.BlockSize {
+		plain := make([]byte, aes.BlockSize)
+		block.Decrypt(plain, wrapped)
+		copy(iv[:], plain)
+		padded = plain[8:]
+	} else {
+		iv, padded = unwrap(block, wrapped)
+	}
+
+	// Check the constant, the length and the padding in constant time.
+	length := binary.BigEndian.Uint32(iv[4:])
+	valid := subtle.ConstantTimeCompare(iv[:4], keyWrapPaddingIV[:])
+	valid &= subtle.ConstantTimeLessOrEq(len(padded)-7, int(length))
+	valid &= subtle.ConstantTimeLessOrEq(int(length), len(padded))
+	if valid != 1 {
+		clear(padded)
+		return nil, CipherTextError("key unwrap failed")
+	}
+	var nonZero byte
+	for _, b := range padded[length:] {
+		nonZero |= b
+	}
+	if nonZero != 0 {
+		clear(padded)
+		return nil, CipherTextError("key unwrap failed")
+	}
+
+	return padded[:length], nil
+}
+
+func newKeyWrapCipher(kek []byte) (cipher.Block, error) {
+	if len(kek) != 16 && len(kek) != 24 && len(kek) != 32 {
+		return nil, EncryptionKeyError("key-encryption key must be 16, 24 or 32 bytes")
+	}
+
+	return aes.NewCipher(kek)
+}
+
+// wrap implements the wrapping process W of RFC 3394 with the given initial value.
+func wrap(block cipher.Block, iv [8]byte, key []byte) []byte {
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out[8:], key)
+
+	var b [aes.BlockSize]byte
+	a := iv
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := out[8*i : 8*i+8]
+			copy(b[:8], a[:])
+			copy(b[8:], r)
+			block.Encrypt(b[:], b[:])
+			binary.BigEndian.PutUint64(a[:], binary.BigEndian.Uint64(b[:8])^uint64(n*j+i))
+			copy(r, b[8:])
+		}
+	}
+	copy(out, a[:])
+
+	return out
+}
+
+// unwrap implements the unwrapping process W^-1 of RFC 3394, it returns the initial value and the key.
+func unwrap(block cipher.Block, wrapped []byte) ([8]byte, []byte) {
+	n := len(wrapped)/8 - 1
+	key := make([]byte, len(wrapped)-8)
+	copy(key, wrapped[8:])
+
+	var b [aes.BlockSize]byte
+	var a [8]byte
+	copy(a[:], wrapped[:8])
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := key[8*(i-1) : 8*i]
+			binary.BigEndian.PutUint64(b[:8], binary.BigEndian.Uint64(a[:])^uint64(n*j+i))
+			copy(b[8:], r)
+			block.Decrypt(b[:], b[:])
+			copy(a[:], b[:8])
+			copy(r, b[8:])
+		}
+	}
+
+	return a, key
+}
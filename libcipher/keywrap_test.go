@@ -0,0 +1,56 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestKeyWrap_Vectors(t *testing.T) {
+	// RFC 3394 section 4 and RFC 5649 section 6.
+	var testCases = []struct {
+		name    string
+		kek     string
+		key     string
+		wrapped string
+		padding bool
+	}{
+		{name: "RFC3394_128KEK_128Key", kek: "000102030405060708090A0B0C0D0E0F", key: "00112233445566778899AABBCCDDEEFF", wrapped: "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		{name: "RFC3394_256KEK_256Key", kek: "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", key: "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F", wrapped: "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+		{name: "RFC5649_20Bytes", kek: "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", key: "c37b7e6492584340bed12207808941155068f738", wrapped: "138bdeaa9b8fa7fc61f97742e72248ee5ae6ae5360d1ae6a5f54f373fa543b6a", padding: true},
+		{name: "RFC5649_7Bytes", kek: "5840df6e29b02af1ab493b705bf16ea1ae8338f4dcc176a8", key: "466f7250617369", wrapped: "afbeb0f07dfbf5419200f2ccb50bb24f", padding: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kek, _ := hex.DecodeString(tc.kek)
+			key, _ := hex.DecodeString(tc.key)
+			expected, _ := hex.DecodeString(tc.wrapped)
+			wrapFunc, unwrapFunc := libcipher.WrapKey, libcipher.UnwrapKey
+			if tc.padding {
+				wrapFunc, unwrapFunc = libcipher.WrapKeyWithPadding, libcipher.UnwrapKeyWithPadding
+			}
+
+			wrapped, err := wrapFunc(kek, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(wrapped, expected) {
+				t.Fatalf("wrapped key mismatch: %x : %x", wrapped, expected)
+			}
+			unwrapped, err := unwrapFunc(kek, wrapped)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(unwrapped, key) {
+				t.Fatalf("unwrapped key mismatch: %x : %x", unwrapped, key)
+			}
+
+			wrapped[len(wrapped)-1] ^= 1
+			if _, err := unwrapFunc(kek, wrapped); err == nil {
+				t.Fatal("expected an error unwrapping a tampered key")
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package libcipher
+
+import (
+	"fmt"
+	"sync"
+)
+
+type (
+	UsageLimitError string
+)
+
+func (e UsageLimitError) Error() string {
+	return "libcipher/cipher: " + (string)(e)
+}
+
+// MaxGCMRandomNonceMessages is the number of messages a key may seal with random 96 bit GCM nonces (NIST SP 800-38D, 8.3).
+const MaxGCMRandomNonceMessages = 1 << 32
+
+// Usage counts the messages and plaintext bytes sealed with a key.
+type Usage struct {
+	Messages uint64
+	Bytes    uint64
+}
+
+// UsageLimits configures NewLimitedEncryptor, a zero value disables a limit.
+type UsageLimits struct {
+	// MaxMessages & MaxBytes are hard limits, encryptions exceeding them are refused with a UsageLimitError.
+	MaxMessages uint64
+	MaxBytes    uint64
+	// RotateMessages & RotateBytes are soft limits, encryption goes on after OnRotate was called.
+	// They should be set below the hard limits to leave time for a rotation.
+	RotateMessages uint64
+	RotateBytes    uint64
+	// OnRotate is called once with the usage at the time the first soft limit was reached.
+	// It is called while sealing, it must not block & must not encrypt with the same encryptor.
+	OnRotate func(Usage)
+}
+
+// usageState is shared by all copies of a LimitedEncryptor.
+type usageState struct {
+	mu      sync.Mutex
+	usage   Usage
+	rotated bool
+}
+
+// LimitedEncryptor counts the usage of a key and enforces UsageLimits.
+type LimitedEncryptor struct {
+	encryptor Encryptor
+	limits    UsageLimits
+	state     *usageState
+}
+
+// NewLimitedEncryptor wraps encryptor counting the messages & bytes it seals.
+// Use one LimitedEncryptor per key, e.g. with MaxMessages set to MaxGCMRandomNonceMessages for AES-GCM with random nonces.
+// The usage is kept in memory only, after a restart the usage persisted by the caller can be restored with NewLimitedEncryptorWithUsage.
+//
+// Messages are counted before they are sealed, failed encryptions count as well, as they may have used a nonce.
+// It can be added to a Keyring if the wrapped encryptor can, otherwise Keyring.Add fails. All copies made by the keyring share the usage.
+func NewLimitedEncryptor(encryptor Encryptor, limits UsageLimits) (*LimitedEncryptor, error) {
+	return NewLimitedEncryptorWithUsage(encryptor, limits, Usage{})
+}
+
+// NewLimitedEncryptorWithUsage creates a LimitedEncryptor continuing from an earlier usage of the key.
+func NewLimitedEncryptorWithUsage(encryptor Encryptor, limits UsageLimits, usage Usage) (*LimitedEncryptor, error) {
+	if encryptor == nil {
+		return nil, InvalidUsageError("encryptor was nil")
+	}
+	if (limits.RotateMessages != 0 || limits.RotateBytes != 0) && limits.OnRotate == nil {
+		return nil, InvalidUsageError("soft limits need an OnRotate callback")
+	}
+
+	return &LimitedEncryptor{encryptor: encryptor, limits: limits, state: &usageState{usage: usage}}, nil
+}
+
+// Crypt seals the message if it stays within the hard limits & counts it.
+func (l *LimitedEncryptor) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	usage, rotate, err := l.count(uint64(len(message)))
+	if err != nil {
+		return nil, err
+	}
+	if rotate {
+		l.limits.OnRotate(usage)
+	}
+
+	return l.encryptor.Crypt(message, additionalData)
+}
+
+// Usage returns the messages & bytes counted so far.
+func (l *LimitedEncryptor) Usage() Usage {
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+
+	return l.state.usage
+}
+
+// NeedsRotation reports whether a soft or hard limit was reached.
+func (l *LimitedEncryptor) NeedsRotation() bool {
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+
+	return l.state.rotated || l.reached(l.state.usage, l.limits.MaxMessages, l.limits.MaxBytes)
+}
+
+// count adds a message of length bytes to the usage, unless it would exceed a hard limit.
+// It reports whether the message reached a soft limit for the first time.
+func (l *LimitedEncryptor) count(length uint64) (Usage, bool, error) {
+	l.state.mu.Lock()
+	defer l.state.mu.Unlock()
+
+	usage := l.state.usage
+	if (l.limits.MaxMessages != 0 && usage.Messages >= l.limits.MaxMessages) ||
+		(l.limits.MaxBytes != 0 && (usage.Bytes > l.limits.MaxBytes || length > l.limits.MaxBytes-usage.Bytes)) {
+		return usage, false, UsageLimitError(fmt.Sprintf("key usage limit reached after %d messages and %d bytes, rotation needed", usage.Messages, usage.Bytes))
+	}
+	usage.Messages++
+	usage.Bytes += length
+	l.state.usage = usage
+
+	rotate := !l.state.rotated && l.reached(usage, l.limits.RotateMessages, l.limits.RotateBytes)
+	l.state.rotated = l.state.rotated || rotate
+
+	return usage, rotate, nil
+}
+
+// reached reports whether usage reached the given limits.
+func (l *LimitedEncryptor) reached(usage Usage, messages uint64, bytes uint64) bool {
+	return (messages != 0 && usage.Messages >= messages) || (bytes != 0 && usage.Bytes >= bytes)
+}
@@ -0,0 +1,211 @@
+package libcipher_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func newTestGCM(t *testing.T, key []byte) (libcipher.Encryptor, libcipher.Decryptor) {
+	t.Helper()
+	encryptor, err := libcipher.NewGCMEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewGCMDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encryptor, decryptor
+}
+
+func TestLimitedEncryptor(t *testing.T) {
+	var testCases = []struct {
+		name     string
+		limits   libcipher.UsageLimits
+		messages int
+		sealed   int
+		rotateAt uint64
+	}{
+		{
+			name:     "Unlimited",
+			messages: 10,
+			sealed:   10,
+		},
+		{
+			name:     "MaxMessages",
+			limits:   libcipher.UsageLimits{MaxMessages: 3},
+			messages: 5,
+			sealed:   3,
+		},
+		{
+			name:     "MaxBytes",
+			limits:   libcipher.UsageLimits{MaxBytes: 25},
+			messages: 5,
+			sealed:   2,
+		},
+		{
+			name:     "RotateMessages",
+			limits:   libcipher.UsageLimits{RotateMessages: 4, MaxMessages: 6},
+			messages: 8,
+			sealed:   6,
+			rotateAt: 4,
+		},
+		{
+			name:     "RotateBytes",
+			limits:   libcipher.UsageLimits{RotateBytes: 30},
+			messages: 5,
+			sealed:   5,
+			rotateAt: 3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			inner, decryptor := newTestGCM(t, []byte("mysecretencryptionkey12345671234"))
+			var rotations []libcipher.Usage
+			if tc.limits.RotateMessages != 0 || tc.limits.RotateBytes != 0 {
+				tc.limits.OnRotate = func(usage libcipher.Usage) { rotations = append(rotations, usage) }
+			}
+			encryptor, err := libcipher.NewLimitedEncryptor(inner, tc.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sealed := 0
+			for i := 0; i < tc.messages; i++ {
+				cipherpackage, err := encryptor.Crypt([]byte("ten bytes!"), nil)
+				var limitError libcipher.UsageLimitError
+				if errors.As(err, &limitError) {
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, _, err := decryptor.Crypt(cipherpackage); err != nil {
+					t.Fatal(err)
+				}
+				sealed++
+			}
+			if sealed != tc.sealed {
+				t.Fatalf("expected %d sealed messages, got %d", tc.sealed, sealed)
+			}
+			if usage := encryptor.Usage(); usage.Messages != uint64(tc.sealed) || usage.Bytes != uint64(10*tc.sealed) {
+				t.Fatalf("unexpected usage %+v", usage)
+			}
+			if tc.rotateAt == 0 {
+				if len(rotations) != 0 {
+					t.Fatalf("unexpected rotations %v", rotations)
+				}
+				return
+			}
+			if len(rotations) != 1 || rotations[0].Messages != tc.rotateAt {
+				t.Fatalf("expected a single rotation at %d messages, got %v", tc.rotateAt, rotations)
+			}
+			if !encryptor.NeedsRotation() {
+				t.Fatal("expected NeedsRotation after the soft limit")
+			}
+		})
+	}
+}
+
+func TestLimitedEncryptor_Usage(t *testing.T) {
+	inner, _ := newTestGCM(t, []byte("mysecretencryptionkey12345671234"))
+	if _, err := libcipher.NewLimitedEncryptor(inner, libcipher.UsageLimits{RotateMessages: 1}); err == nil {
+		t.Fatal("expected an error for soft limits without OnRotate")
+	}
+
+	// A restored usage counts towards the limits.
+	encryptor, err := libcipher.NewLimitedEncryptorWithUsage(inner, libcipher.UsageLimits{MaxMessages: 10}, libcipher.Usage{Messages: 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encryptor.NeedsRotation() {
+		t.Fatal("unexpected NeedsRotation below the limit")
+	}
+	if _, err := encryptor.Crypt([]byte("message"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !encryptor.NeedsRotation() {
+		t.Fatal("expected NeedsRotation at the hard limit")
+	}
+	if _, err := encryptor.Crypt([]byte("message"), nil); err == nil {
+		t.Fatal("expected an error beyond the hard limit")
+	}
+}
+
+func TestLimitedEncryptor_Keyring(t *testing.T) {
+	keyring := libcipher.NewKeyring()
+	oldEncryptor, oldDecryptor := newTestGCM(t, []byte("mysecretencryptionkey12345671234"))
+	newEncryptor, newDecryptor := newTestGCM(t, []byte("anothersecretencryptionkey123456"))
+
+	// The keyring switches to the new key once the old one needs rotation.
+	if err := keyring.Add(2, newEncryptor, newDecryptor); err != nil {
+		t.Fatal(err)
+	}
+	limited, err := libcipher.NewLimitedEncryptor(oldEncryptor, libcipher.UsageLimits{
+		RotateMessages: 2,
+		MaxMessages:    3,
+		OnRotate: func(libcipher.Usage) {
+			if err := keyring.SetActive(2); err != nil {
+				t.Error(err)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add(1, limited, oldDecryptor); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetActive(1); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		cipherpackage, err := keyring.Encryptor().Crypt([]byte("message"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, err := libcipher.ParseHeader(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[bool]uint32{true: 1, false: 2}[i < 2]; header.KeyID != expected {
+			t.Fatalf("message %d: expected key %d, got %d", i, expected, header.KeyID)
+		}
+		if _, _, err := keyring.Decryptor().Crypt(cipherpackage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if usage := limited.Usage(); usage.Messages != 2 {
+		t.Fatalf("expected the keyring copy to share the usage, got %+v", usage)
+	}
+}
+
+func TestLimitedEncryptor_KeyringUnidentifiable(t *testing.T) {
+	// A signer can't stamp key ids, neither can a LimitedEncryptor wrapping it.
+	inner, decryptor := newTestGCM(t, []byte("mysecretencryptionkey12345671234"))
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := libcipher.NewSigner(inner, 1, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited, err := libcipher.NewLimitedEncryptor(signer, libcipher.UsageLimits{MaxMessages: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := libcipher.NewKeyring()
+	if err := keyring.Add(1, limited, decryptor); err == nil || err.Error() != "libcipher/cipher: encryptor does not support key ids" {
+		t.Fatalf("expected the keyring to refuse the encryptor, got %v", err)
+	}
+	if _, err := keyring.Encryptor().Crypt([]byte("message"), nil); err == nil {
+		t.Fatal("expected no active key")
+	}
+}
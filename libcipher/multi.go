@@ -0,0 +1,457 @@
+package libcipher
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Context labels of the keys derived from the file key of a multi-recipient package.
+const (
+	multiHeaderContext  = "libcipher/multi/header"
+	multiPayloadContext = "libcipher/multi/payload"
+	multiX25519Context  = "libcipher/multi/x25519"
+)
+
+// multiFileKeySize is the size of the random file key of a multi-recipient package.
+const multiFileKeySize = 32
+
+// Stanza types, one per kind of recipient.
+const (
+	stanzaSymmetric byte = 1
+	stanzaX25519    byte = 2
+)
+
+// stanza holds the file key wrapped for one recipient.
+//
+//	[ Type | ID-Length (2 bytes) | ID | Body-Length (2 bytes) | Body ]
+type stanza struct {
+	kind byte
+	id   []byte
+	body []byte
+}
+
+// Recipient is a party a multi-recipient package can be encrypted to.
+type Recipient interface {
+	stanzaID() (byte, []byte)
+	wrapFileKey(fileKey []byte, rand io.Reader) ([]byte, error)
+}
+
+// Identity is able to unwrap the file key of a multi-recipient package.
+type Identity interface {
+	stanzaID() (byte, []byte)
+	unwrapFileKey(body []byte) ([]byte, error)
+}
+
+// SymmetricRecipient is a key-encryption key shared with a recipient, it is both Recipient & Identity.
+type SymmetricRecipient struct {
+	id  uint32
+	kek []byte
+}
+
+// NewSymmetricRecipient creates a recipient for the key-encryption key kek of 16, 24 or 32 bytes.
+// The file key is wrapped with AES-KWP (RFC 5649), the id names the key in the package.
+func NewSymmetricRecipient(id uint32, kek []byte) (*SymmetricRecipient, error) {
+	if _, err := newKeyWrapCipher(kek); err != nil {
+		return nil, err
+	}
+
+	return &SymmetricRecipient{id: id, kek: bytes.Clone(kek)}, nil
+}
+
+func (r *SymmetricRecipient) stanzaID() (byte, []byte) {
+	return stanzaSymmetric, binary.BigEndian.AppendUint32(nil, r.id)
+}
+
+func (r *SymmetricRecipient) wrapFileKey(fileKey []byte, _ io.Reader) ([]byte, error) {
+	return WrapKeyWithPadding(r.kek, fileKey)
+}
+
+func (r *SymmetricRecipient) unwrapFileKey(body []byte) ([]byte, error) {
+	return UnwrapKeyWithPadding(r.kek, body)
+}
+
+// X25519Recipient is the public key of a recipient.
+type X25519Recipient struct {
+	publicKey *ecdh.PublicKey
+}
+
+// NewX25519Recipient creates a recipient for an X25519 public key.
+// The file key is sealed as by NewX25519Encryptor, the public key names the recipient in the package.
+func NewX25519Recipient(publicKey *ecdh.PublicKey) (*X25519Recipient, error) {
+	if publicKey == nil || publicKey.Curve() != ecdh.X25519() {
+		return nil, EncryptionKeyError("recipient must be an X25519 public key")
+	}
+
+	return &X25519Recipient{publicKey: publicKey}, nil
+}
+
+func (r *X25519Recipient) stanzaID() (byte, []byte) {
+	return stanzaX25519, r.publicKey.Bytes()
+}
+
+// wrapFileKey seals the file key, the body is ( Ephemeral Public Key | Sealed File Key ).
+func (r *X25519Recipient) wrapFileKey(fileKey []byte, rand io.Reader) ([]byte, error) {
+	ephemeral, err := GenerateX25519Identity(rand)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	aead, err := newX25519AEAD(shared, ephemeralPublic, r.publicKey.Bytes(), multiX25519Context)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(ephemeralPublic, nil, fileKey, nil), nil
+}
+
+// X25519Identity is the private key of a recipient.
+type X25519Identity struct {
+	privateKey *ecdh.PrivateKey
+}
+
+// NewX25519Identity creates an identity for an X25519 private key.
+func NewX25519Identity(privateKey *ecdh.PrivateKey) (*X25519Identity, error) {
+	if privateKey == nil || privateKey.Curve() != ecdh.X25519() {
+		return nil, EncryptionKeyError("identity must be an X25519 private key")
+	}
+
+	return &X25519Identity{privateKey: privateKey}, nil
+}
+
+func (i *X25519Identity) stanzaID() (byte, []byte) {
+	return stanzaX25519, i.privateKey.PublicKey().Bytes()
+}
+
+func (i *X25519Identity) unwrapFileKey(body []byte) ([]byte, error) {
+	if len(body) < 32 {
+		return nil, CipherTextError("wrapped key is invalid")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(body[:32])
+	if err != nil {
+		return nil, CipherTextError("invalid ephemeral public key")
+	}
+	shared, err := i.privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, CipherTextError("invalid ephemeral public key")
+	}
+	aead, err := newX25519AEAD(shared, body[:32], i.privateKey.PublicKey().Bytes(), multiX25519Context)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, nil, body[32:], nil)
+}
+
+// encryptorMulti seals every message once and wraps its file key for every recipient.
+type encryptorMulti struct {
+	recipients []Recipient
+	rand       io.Reader
+}
+
+// decryptorMulti opens the packages any of its identities is a recipient of.
+type decryptorMulti struct {
+	identities []Identity
+}
+
+// NewMultiRecipientEncryptor creates an Encryptor whose packages can be opened by every one of the recipients.
+// The message is encrypted once under a random file key, the file key is wrapped separately for every recipient.
+//
+//	The final encrypted string format:
+//	[ Header | Stanza-Count (2 bytes) | Stanza 1 | ... | Stanza n | Header MAC | AD-Lenght (4 bytes) | AD | Ciphertext | Authentication Tag ]
+//
+// The header MAC is an HMAC-SHA256 over everything in front of it, keyed by a key derived from the file key.
+// The payload is sealed with ChaCha20-Poly1305 under a key derived from the file key, AD-Lenght & AD are authenticated.
+// AddRecipient & RemoveRecipient change the stanzas without touching the payload.
+//
+// Every recipient learns the file key, a recipient can alter the stanzas of a package it is able to open.
+func NewMultiRecipientEncryptor(recipients []Recipient, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	if len(recipients) == 0 {
+		return nil, InvalidUsageError("no recipient given")
+	}
+	if len(recipients) > 65535 {
+		return nil, InvalidUsageError("too many recipients")
+	}
+
+	return encryptorMulti{recipients: append([]Recipient(nil), recipients...), rand: rand}, nil
+}
+
+// NewMultiRecipientDecryptor creates a Decryptor for multi-recipient packages, the identities are tried in order.
+func NewMultiRecipientDecryptor(identities ...Identity) (Decryptor, error) {
+	if len(identities) == 0 {
+		return nil, InvalidUsageError("no identity given")
+	}
+
+	return decryptorMulti{identities: append([]Identity(nil), identities...)}, nil
+}
+
+// Crypt encrypts the message once and wraps its file key for every recipient.
+func (e encryptorMulti) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError("message was nil")
+	}
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+	fileKey := make([]byte, multiFileKeySize)
+	if _, err := io.ReadFull(e.rand, fileKey); err != nil {
+		return nil, err
+	}
+	defer clear(fileKey)
+
+	stanzas := make([]stanza, 0, len(e.recipients))
+	for _, recipient := range e.recipients {
+		s, err := newStanza(recipient, fileKey, e.rand)
+		if err != nil {
+			return nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+
+	// Seal the payload.
+	payloadKey, err := DeriveKey(fileKey, nil, multiPayloadContext, chacha20poly1305.KeySize, sha256.New)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(payloadKey)
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, 4, 4+len(additionalData)+len(message)+aead.Overhead())
+	putAdditionalDataLength(payload, HeaderVersion2, len(additionalData))
+	payload = append(payload, additionalData...)
+	payload = singleUseAEAD{aead}.Seal(payload, nil, message, payload)
+
+	return assembleMulti(fileKey, stanzas, payload)
+}
+
+// Crypt decrypts a package with the first identity that is one of its recipients.
+func (d decryptorMulti) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	_, payload, fileKey, err := openMulti(cipherpackage, d.identities)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(fileKey)
+
+	payloadKey, err := DeriveKey(fileKey, nil, multiPayloadContext, chacha20poly1305.KeySize, sha256.New)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer clear(payloadKey)
+	aead, err := chacha20poly1305.New(payloadKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(payload) < 4 {
+		return nil, nil, CipherTextError("cipherText is too short")
+	}
+	adLength := additionalDataLength(payload, HeaderVersion2)
+	if adLength > uint64(len(payload)-4) {
+		return nil, nil, CipherTextError("cipherText is too short for additional data")
+	}
+	dataLocation := 4 + int(adLength)
+	plaintext, err := singleUseAEAD{aead}.Open(nil, nil, payload[dataLocation:], payload[:dataLocation])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return plaintext, payload[4:dataLocation], nil
+}
+
+// AddRecipient adds a recipient to a multi-recipient package without re-encrypting the payload.
+// The identity has to be a recipient of the package already, adding an existing recipient fails.
+func AddRecipient(cipherpackage []byte, identity Identity, recipient Recipient, rand io.Reader) ([]byte, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	stanzas, payload, fileKey, err := openMulti(cipherpackage, []Identity{identity})
+	if err != nil {
+		return nil, err
+	}
+	defer clear(fileKey)
+	kind, id := recipient.stanzaID()
+	if indexStanza(stanzas, kind, id) >= 0 {
+		return nil, InvalidUsageError("recipient already exists")
+	}
+	s, err := newStanza(recipient, fileKey, rand)
+	if err != nil {
+		return nil, err
+	}
+
+	return assembleMulti(fileKey, append(stanzas, s), payload)
+}
+
+// RemoveRecipient removes a recipient from a multi-recipient package without re-encrypting the payload.
+// The identity has to be a recipient of the package, the last recipient can't be removed.
+//
+// A removed recipient keeps access to copies of the package it already has and knows the file key,
+// encrypt the message anew if that matters.
+func RemoveRecipient(cipherpackage []byte, identity Identity, recipient Recipient) ([]byte, error) {
+	stanzas, payload, fileKey, err := openMulti(cipherpackage, []Identity{identity})
+	if err != nil {
+		return nil, err
+	}
+	defer clear(fileKey)
+	kind, id := recipient.stanzaID()
+	i := indexStanza(stanzas, kind, id)
+	if i < 0 {
+		return nil, InvalidUsageError("recipient does not exist")
+	}
+	if len(stanzas) == 1 {
+		return nil, InvalidUsageError("the last recipient can't be removed")
+	}
+
+	return assembleMulti(fileKey, append(stanzas[:i:i], stanzas[i+1:]...), payload)
+}
+
+func newStanza(recipient Recipient, fileKey []byte, rand io.Reader) (stanza, error) {
+	kind, id := recipient.stanzaID()
+	body, err := recipient.wrapFileKey(fileKey, rand)
+	if err != nil {
+		return stanza{}, err
+	}
+
+	return stanza{kind: kind, id: id, body: body}, nil
+}
+
+func indexStanza(stanzas []stanza, kind byte, id []byte) int {
+	for i, s := range stanzas {
+		if s.kind == kind && bytes.Equal(s.id, id) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// assembleMulti encodes a multi-recipient package and calculates its header MAC.
+func assembleMulti(fileKey []byte, stanzas []stanza, payload []byte) ([]byte, error) {
+	if len(stanzas) > 65535 {
+		return nil, InvalidUsageError("too many recipients")
+	}
+	cipherpackage := Header{Version: HeaderVersion2, Algorithm: AlgorithmMulti}.append(nil)
+	cipherpackage = binary.BigEndian.AppendUint16(cipherpackage, uint16(len(stanzas)))
+	for _, s := range stanzas {
+		cipherpackage = append(cipherpackage, s.kind)
+		cipherpackage = binary.BigEndian.AppendUint16(cipherpackage, uint16(len(s.id)))
+		cipherpackage = append(cipherpackage, s.id...)
+		cipherpackage = binary.BigEndian.AppendUint16(cipherpackage, uint16(len(s.body)))
+		cipherpackage = append(cipherpackage, s.body...)
+	}
+	mac, err := multiHeaderMAC(fileKey, cipherpackage)
+	if err != nil {
+		return nil, err
+	}
+	cipherpackage = append(cipherpackage, mac...)
+
+	return append(cipherpackage, payload...), nil
+}
+
+// openMulti parses a multi-recipient package, unwraps its file key with the first matching identity and verifies the header MAC.
+// It returns the stanzas, the payload and the file key.
+func openMulti(cipherpackage []byte, identities []Identity) ([]stanza, []byte, []byte, error) {
+	header, headerLength, err := ParseHeader(cipherpackage)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := header.check(AlgorithmMulti, 0); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Parse the stanzas.
+	rest := cipherpackage[headerLength:]
+	if len(rest) < 2 {
+		return nil, nil, nil, CipherTextError("cipherText is too short")
+	}
+	count := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	stanzas := make([]stanza, 0, count)
+	for i := 0; i < count; i++ {
+		var s stanza
+		if len(rest) < 3 {
+			return nil, nil, nil, CipherTextError("cipherText is too short for the stanzas")
+		}
+		s.kind = rest[0]
+		s.id, rest, err = readLengthPrefixed(rest[1:])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		s.body, rest, err = readLengthPrefixed(rest)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		stanzas = append(stanzas, s)
+	}
+	macLocation := len(cipherpackage) - len(rest)
+	if len(rest) < sha256.Size {
+		return nil, nil, nil, CipherTextError("cipherText is too short for the header MAC")
+	}
+
+	// Unwrap the file key.
+	var fileKey []byte
+	for _, identity := range identities {
+		kind, id := identity.stanzaID()
+		i := indexStanza(stanzas, kind, id)
+		if i < 0 {
+			continue
+		}
+		if fileKey, err = identity.unwrapFileKey(stanzas[i].body); err == nil {
+			break
+		}
+	}
+	if fileKey == nil {
+		return nil, nil, nil, CipherTextError("no identity is a recipient of the package")
+	}
+
+	mac, err := multiHeaderMAC(fileKey, cipherpackage[:macLocation])
+	if err != nil {
+		clear(fileKey)
+		return nil, nil, nil, err
+	}
+	if !hmac.Equal(mac, rest[:sha256.Size]) {
+		clear(fileKey)
+		return nil, nil, nil, fmt.Errorf("data integrity compromised %w", CipherTextError("header MAC verification failed"))
+	}
+
+	return stanzas, rest[sha256.Size:], fileKey, nil
+}
+
+func multiHeaderMAC(fileKey []byte, header []byte) ([]byte, error) {
+	macKey, err := DeriveKey(fileKey, nil, multiHeaderContext, sha256.Size, sha256.New)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(macKey)
+
+	return generateSignatureParts(macKey, sha256.New, header), nil
+}
+
+// readLengthPrefixed splits a field with a 2 byte length prefix from src.
+func readLengthPrefixed(src []byte) ([]byte, []byte, error) {
+	if len(src) < 2 {
+		return nil, nil, CipherTextError("cipherText is too short for the stanzas")
+	}
+	length := int(binary.BigEndian.Uint16(src))
+	if len(src) < 2+length {
+		return nil, nil, CipherTextError("cipherText is too short for the stanzas")
+	}
+
+	return src[2 : 2+length], src[2+length:], nil
+}
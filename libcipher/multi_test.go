@@ -0,0 +1,147 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestMulti_EncryptDecrypt(t *testing.T) {
+	alice, bob, carol := newTestX25519(t), newTestX25519(t), newTestX25519(t)
+	ci, err := libcipher.NewSymmetricRecipient(1, bytes.Repeat([]byte{0x01}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := libcipher.NewMultiRecipientEncryptor([]libcipher.Recipient{alice.recipient, bob.recipient, ci}, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases = []struct {
+		name     string
+		identity libcipher.Identity
+		ok       bool
+	}{
+		{name: "Alice", identity: alice.identity, ok: true},
+		{name: "Bob", identity: bob.identity, ok: true},
+		{name: "Symmetric", identity: ci, ok: true},
+		{name: "Carol", identity: carol.identity, ok: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decryptor, err := libcipher.NewMultiRecipientDecryptor(tc.identity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, ad, err := decryptor.Crypt(cipherpackage)
+			if !tc.ok {
+				if err == nil {
+					t.Fatal("expected an error decrypting as non-recipient")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, []byte("message")) || !bytes.Equal(ad, []byte("ad")) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+			}
+		})
+	}
+
+	decryptor, err := libcipher.NewMultiRecipientDecryptor(alice.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, location := range []int{testHeaderSize + 5, len(cipherpackage) - 1} {
+		tampered := bytes.Clone(cipherpackage)
+		tampered[location] ^= 1
+		if _, _, err := decryptor.Crypt(tampered); err == nil {
+			t.Fatalf("expected an error decrypting a package tampered at %d", location)
+		}
+	}
+}
+
+func TestMulti_AddRemoveRecipient(t *testing.T) {
+	alice, bob := newTestX25519(t), newTestX25519(t)
+	encryptor, err := libcipher.NewMultiRecipientEncryptor([]libcipher.Recipient{alice.recipient}, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobDecryptor, err := libcipher.NewMultiRecipientDecryptor(bob.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceDecryptor, err := libcipher.NewMultiRecipientDecryptor(alice.identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bobDecryptor.Crypt(cipherpackage); err == nil {
+		t.Fatal("expected an error decrypting before bob was added")
+	}
+	if _, err := libcipher.AddRecipient(cipherpackage, bob.identity, bob.recipient, rand.Reader); err == nil {
+		t.Fatal("expected an error adding a recipient as non-recipient")
+	}
+
+	added, err := libcipher.AddRecipient(cipherpackage, alice.identity, bob.recipient, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The payload is left untouched.
+	if !bytes.HasSuffix(added, cipherpackage[len(cipherpackage)-20:]) {
+		t.Fatal("expected the payload to be unchanged")
+	}
+	if _, err := libcipher.AddRecipient(added, alice.identity, bob.recipient, rand.Reader); err == nil {
+		t.Fatal("expected an error adding an existing recipient")
+	}
+	decrypted, _, err := bobDecryptor.Crypt(added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, []byte("message")) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+	}
+
+	removed, err := libcipher.RemoveRecipient(added, bob.identity, alice.recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := aliceDecryptor.Crypt(removed); err == nil {
+		t.Fatal("expected an error decrypting after alice was removed")
+	}
+	if _, err := libcipher.RemoveRecipient(removed, bob.identity, bob.recipient); err == nil {
+		t.Fatal("expected an error removing the last recipient")
+	}
+}
+
+type testX25519 struct {
+	recipient *libcipher.X25519Recipient
+	identity  *libcipher.X25519Identity
+}
+
+func newTestX25519(t *testing.T) testX25519 {
+	t.Helper()
+	privateKey, err := libcipher.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := libcipher.NewX25519Recipient(privateKey.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := libcipher.NewX25519Identity(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testX25519{recipient: recipient, identity: identity}
+}
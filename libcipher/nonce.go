@@ -0,0 +1,263 @@
+package libcipher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type (
+	NonceError string
+)
+
+func (e NonceError) Error() string {
+	return "libcipher/cipher: " + (string)(e)
+}
+
+// NonceSource provides the nonces of an encryptor, no nonce may be returned twice for the same key.
+type NonceSource interface {
+	// Nonce fills nonce with the next nonce.
+	Nonce(nonce []byte) error
+}
+
+// randomNonceSource draws every nonce from a random source.
+type randomNonceSource struct {
+	rand io.Reader
+}
+
+// NewRandomNonceSource creates a NonceSource reading every nonce from rand, e.g. crypto/rand.Reader.
+// With the 12 byte nonces of GCM a key must not seal more than 2^32 messages to keep the collision probability negligible.
+func NewRandomNonceSource(rand io.Reader) NonceSource {
+	return randomNonceSource{rand: rand}
+}
+
+func (s randomNonceSource) Nonce(nonce []byte) error {
+	_, err := io.ReadFull(s.rand, nonce)
+	return err
+}
+
+// Magic number of the state file of a CounterNonceSource.
+var counterStateMagic = [4]byte{'U', '8', 'N', 'C'}
+
+// CounterNonceSource provides never-repeating nonces from a counter persisted in a state file.
+//
+//	Nonce format:
+//	[ Prefix | Counter (big endian) ]
+//
+// Ranges of reserve counter values are persisted ahead of use, the state file is written to a temporary file,
+// synced & renamed over the old one. After a crash at most the unused part of the last range is skipped, no nonce is repeated.
+//
+// The prefix identifies the instance. Every instance sharing a key needs its own prefix and its own state file,
+// the prefix is stored in the state file and a different one is rejected.
+//
+// The state file is locked by a lock file next to it (path + ".lock") until Close, a second open of the same state file fails.
+// A lock file left behind by a crashed process must be removed by hand, after making sure the process is gone.
+type CounterNonceSource struct {
+	mu      sync.Mutex
+	path    string
+	lock    string
+	prefix  []byte
+	reserve uint64
+	// next is the counter of the next nonce, limit the end of the persisted range.
+	next   uint64
+	limit  uint64
+	closed bool
+}
+
+// NewCounterNonceSource opens the counter persisted at path, the state file is created if it doesn't exist.
+// reserve is the number of nonces persisted at once, larger ranges need fewer writes but skip more nonces after a crash.
+func NewCounterNonceSource(path string, prefix []byte, reserve uint64) (*CounterNonceSource, error) {
+	if reserve == 0 {
+		return nil, InvalidUsageError("nonce reserve must be positive")
+	}
+	if len(prefix) > math.MaxUint8 {
+		return nil, InvalidUsageError("nonce prefix too long")
+	}
+	lock, err := lockCounterState(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &CounterNonceSource{path: path, lock: lock, prefix: bytes.Clone(prefix), reserve: reserve}
+	if err := s.load(); err != nil {
+		os.Remove(lock)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads the counter from the state file, a missing state file is created.
+func (s *CounterNonceSource) load() error {
+	state, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.persist(0)
+	}
+	if err != nil {
+		return err
+	}
+	storedPrefix, next, err := parseCounterState(state)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(storedPrefix, s.prefix) {
+		return NonceError("nonce prefix does not match the state file")
+	}
+	s.next, s.limit = next, next
+
+	return nil
+}
+
+// lockCounterState creates the lock file of the state file at path, it fails if the lock file already exists.
+func lockCounterState(path string) (string, error) {
+	lock := path + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return "", NonceError(fmt.Sprintf("nonce state file is in use, remove %s if no process holds it", lock))
+	}
+	if err != nil {
+		return "", err
+	}
+	// The process id helps to find the holder of a stale lock.
+	_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lock)
+		return "", err
+	}
+
+	return lock, nil
+}
+
+// Nonce fills nonce with the prefix followed by the next counter value, a new range is persisted before the reserved one is exhausted.
+func (s *CounterNonceSource) Nonce(nonce []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return NonceError("nonce source is closed")
+	}
+	counterSize := len(nonce) - len(s.prefix)
+	if counterSize <= 0 {
+		return NonceError("nonce prefix too long for the nonce size")
+	}
+	if s.next == math.MaxUint64 || (counterSize < 8 && s.next >= 1<<(8*counterSize)) {
+		return NonceError("nonce counter exhausted")
+	}
+	if s.next == s.limit {
+		limit := s.next + s.reserve
+		if limit < s.next {
+			limit = math.MaxUint64
+		}
+		if err := s.persist(limit); err != nil {
+			return err
+		}
+		s.limit = limit
+	}
+
+	copy(nonce, s.prefix)
+	counter := nonce[len(s.prefix):]
+	clear(counter)
+	if counterSize >= 8 {
+		binary.BigEndian.PutUint64(counter[counterSize-8:], s.next)
+	} else {
+		var encoded [8]byte
+		binary.BigEndian.PutUint64(encoded[:], s.next)
+		copy(counter, encoded[8-counterSize:])
+	}
+	s.next++
+
+	return nil
+}
+
+// Close persists the counter of the next nonce, the rest of the reserved range is not skipped on the next start.
+// The lock of the state file is released, no nonces are provided after Close.
+func (s *CounterNonceSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.next != s.limit {
+		err = s.persist(s.next)
+	}
+	// The persisted range is safe even if persisting failed, the lock is released anyway.
+	if removeErr := os.Remove(s.lock); err == nil {
+		err = removeErr
+	}
+
+	return err
+}
+
+// persist writes the state file with next as the counter of the next nonce after a restart.
+func (s *CounterNonceSource) persist(next uint64) error {
+	state := make([]byte, 0, len(counterStateMagic)+1+len(s.prefix)+8)
+	state = append(state, counterStateMagic[:]...)
+	state = append(state, byte(len(s.prefix)))
+	state = append(state, s.prefix...)
+	state = binary.BigEndian.AppendUint64(state, next)
+	if err := writeFileAtomic(s.path, state); err != nil {
+		return fmt.Errorf("%w: %w", NonceError("persisting nonce counter failed"), err)
+	}
+
+	return nil
+}
+
+// parseCounterState decodes the prefix and the counter of a state file.
+func parseCounterState(state []byte) ([]byte, uint64, error) {
+	if len(state) < len(counterStateMagic)+1 || !bytes.Equal(state[:len(counterStateMagic)], counterStateMagic[:]) {
+		return nil, 0, NonceError("invalid nonce state file")
+	}
+	prefixLength := int(state[len(counterStateMagic)])
+	rest := state[len(counterStateMagic)+1:]
+	if len(rest) != prefixLength+8 {
+		return nil, 0, NonceError("invalid nonce state file")
+	}
+
+	return rest[:prefixLength], binary.BigEndian.Uint64(rest[prefixLength:]), nil
+}
+
+// writeFileAtomic replaces the file at path with data, readers see either the old or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory, so the rename survives a crash.
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
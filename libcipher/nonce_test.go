@@ -0,0 +1,178 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+// counterOf returns the counter of a 12 byte nonce with a 4 byte prefix.
+func counterOf(nonce []byte) uint64 {
+	return binary.BigEndian.Uint64(nonce[4:])
+}
+
+func TestCounterNonceSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nonces")
+	prefix := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	source, err := libcipher.NewCounterNonceSource(path, prefix, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 12)
+	for i := uint64(0); i < 25; i++ {
+		if err := source.Nonce(nonce); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(nonce, prefix) || counterOf(nonce) != i {
+			t.Fatalf("unexpected nonce %x, expected counter %d", nonce, i)
+		}
+	}
+
+	// The state file is locked while the source is open.
+	if _, err := libcipher.NewCounterNonceSource(path, prefix, 10); err == nil {
+		t.Fatal("expected an error for a second open of the state file")
+	}
+
+	// A crash leaves the lock file behind and skips the rest of the reserved range.
+	if err := os.Remove(path + ".lock"); err != nil {
+		t.Fatal(err)
+	}
+	crashed, err := libcipher.NewCounterNonceSource(path, prefix, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crashed.Nonce(nonce); err != nil {
+		t.Fatal(err)
+	}
+	if counterOf(nonce) != 30 {
+		t.Fatalf("expected counter 30 after a crash, got %d", counterOf(nonce))
+	}
+
+	// Close persists the next counter.
+	if err := crashed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := crashed.Nonce(nonce); err == nil {
+		t.Fatal("expected an error after Close")
+	}
+	reopened, err := libcipher.NewCounterNonceSource(path, prefix, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Nonce(nonce); err != nil {
+		t.Fatal(err)
+	}
+	if counterOf(nonce) != 31 {
+		t.Fatalf("expected counter 31 after Close, got %d", counterOf(nonce))
+	}
+
+	// Only the state file is left behind.
+	if err := reopened.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the state file, got %d entries", len(entries))
+	}
+}
+
+func TestCounterNonceSource_Errors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nonces")
+	if _, err := libcipher.NewCounterNonceSource(path, []byte{1}, 0); err == nil {
+		t.Fatal("expected an error for an empty reserve")
+	}
+	source, err := libcipher.NewCounterNonceSource(path, []byte{1}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The prefix is bound to the state file.
+	if _, err := libcipher.NewCounterNonceSource(path, []byte{2}, 100); err == nil {
+		t.Fatal("expected an error for a different prefix")
+	}
+
+	// Corrupted state files are rejected.
+	corrupted := filepath.Join(dir, "corrupted")
+	if err := os.WriteFile(corrupted, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := libcipher.NewCounterNonceSource(corrupted, nil, 100); err == nil {
+		t.Fatal("expected an error for a corrupted state file")
+	}
+
+	// A single counter byte is exhausted after 256 nonces.
+	exhausted, err := libcipher.NewCounterNonceSource(filepath.Join(dir, "exhausted"), make([]byte, 11), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 12)
+	for i := 0; i < 256; i++ {
+		if err := exhausted.Nonce(nonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exhausted.Nonce(nonce); err == nil {
+		t.Fatal("expected an error for an exhausted counter")
+	}
+	if err := exhausted.Nonce(make([]byte, 11)); err == nil {
+		t.Fatal("expected an error for a nonce not longer than the prefix")
+	}
+}
+
+func TestGCMEncryptorWithNonces(t *testing.T) {
+	key := []byte("mysecretencryptionkey12345671234")
+	prefix := []byte{0, 0, 0, 7}
+	source, err := libcipher.NewCounterNonceSource(filepath.Join(t.TempDir(), "nonces"), prefix, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := libcipher.NewGCMEncryptorWithNonces(key, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewGCMDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("additional data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, headerLength, err := libcipher.ParseHeader(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Algorithm != libcipher.AlgorithmGCM {
+			t.Fatalf("unexpected algorithm %s", header.Algorithm)
+		}
+		nonce := cipherpackage[headerLength : headerLength+12]
+		if !bytes.HasPrefix(nonce, prefix) || counterOf(nonce) != i {
+			t.Fatalf("unexpected nonce %x", nonce)
+		}
+		message, ad, err := decryptor.Crypt(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message) != "message" || string(ad) != "additional data" {
+			t.Fatal("decryption returned unexpected data")
+		}
+	}
+
+	if _, err := libcipher.NewGCMEncryptorWithNonces(key, nil); err == nil {
+		t.Fatal("expected an error for a nil nonce source")
+	}
+}
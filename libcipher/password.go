@@ -0,0 +1,341 @@
+package libcipher
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordKDF identifies the function deriving a key from a password.
+type PasswordKDF byte
+
+const (
+	// scrypt (RFC 7914), memory-hard.
+	KDFScrypt PasswordKDF = 1
+	// Argon2id (RFC 9106), memory-hard, preferred for new data.
+	KDFArgon2id PasswordKDF = 2
+	// PBKDF2-HMAC-SHA256 (RFC 8018), not memory-hard, only for compatibility.
+	KDFPBKDF2 PasswordKDF = 3
+)
+
+func (k PasswordKDF) String() string {
+	switch k {
+	case KDFScrypt:
+		return "scrypt"
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFPBKDF2:
+		return "pbkdf2-sha256"
+	}
+
+	return "unknown"
+}
+
+// Upper bounds for parameters read from a package, they keep a crafted package from exhausting the machine.
+// The parameters are read before the package is authenticated, so the total work is capped, not just every parameter.
+const (
+	// maxPasswordCost caps the cost of every KDF, it is the only bound on PBKDF2: 2^21 iterations, about 3.5 times the default.
+	maxPasswordCost = 1 << 21
+	// maxPasswordMemory caps the memory of Argon2id, in KiB (1 GiB).
+	maxPasswordMemory = 1 << 20
+	// maxArgon2idWork caps passes × memory in KiB, e.g. 32 passes over 64 MiB or 2 passes over 1 GiB.
+	maxArgon2idWork = 1 << 21
+	// maxScryptWork caps N × r × p, 8 times the default parameters. scrypt needs 128 × N × r bytes, at most 256 MiB.
+	maxScryptWork = 1 << 21
+
+	passwordSaltSize       = 16
+	passwordKeySize        = 32
+	passwordParamsFixedLen = 1 + 4 + 4 + 1 + 1
+	passwordKeyContext     = "libcipher/password"
+)
+
+// PasswordParams are the cost parameters & salt of a password based key derivation.
+// They are stored in the parameters of the package header.
+//
+//	scrypt:   Cost = N (power of 2), Memory = r, Parallelism = p
+//	Argon2id: Cost = passes (time), Memory = KiB, Parallelism = threads
+//	PBKDF2:   Cost = iterations, Memory and Parallelism are unused
+//
+// The encoded parameters:
+//
+//	[ KDF | Cost (4 bytes) | Memory (4 bytes) | Parallelism | Salt-Length | Salt ]
+type PasswordParams struct {
+	KDF         PasswordKDF
+	Cost        uint32
+	Memory      uint32
+	Parallelism uint8
+	// Salt is generated by the encryptor if empty.
+	Salt []byte
+}
+
+// DefaultPasswordParams returns the recommended parameters of a KDF as of 2024.
+func DefaultPasswordParams(kdf PasswordKDF) PasswordParams {
+	switch kdf {
+	case KDFScrypt:
+		return PasswordParams{KDF: KDFScrypt, Cost: 1 << 15, Memory: 8, Parallelism: 1}
+	case KDFArgon2id:
+		return PasswordParams{KDF: KDFArgon2id, Cost: 3, Memory: 64 * 1024, Parallelism: 4}
+	case KDFPBKDF2:
+		return PasswordParams{KDF: KDFPBKDF2, Cost: 600000}
+	}
+
+	return PasswordParams{}
+}
+
+// validate checks the parameters are usable and within the accepted bounds.
+func (p PasswordParams) validate() error {
+	if p.Cost == 0 || p.Cost > maxPasswordCost {
+		return KeyDerivationError("password cost out of range")
+	}
+	if len(p.Salt) < passwordSaltSize || len(p.Salt) > 255 {
+		return KeyDerivationError("password salt invalid")
+	}
+	switch p.KDF {
+	case KDFScrypt:
+		if p.Cost&(p.Cost-1) != 0 || p.Memory == 0 || p.Parallelism == 0 {
+			return KeyDerivationError("scrypt parameters invalid")
+		}
+		if uint64(p.Cost)*uint64(p.Memory)*uint64(p.Parallelism) > maxScryptWork {
+			return KeyDerivationError("scrypt parameters exceed the work limit")
+		}
+	case KDFArgon2id:
+		if p.Memory == 0 || p.Memory > maxPasswordMemory || p.Parallelism == 0 {
+			return KeyDerivationError("argon2id parameters invalid")
+		}
+		if uint64(p.Cost)*uint64(p.Memory) > maxArgon2idWork {
+			return KeyDerivationError("argon2id parameters exceed the work limit")
+		}
+	case KDFPBKDF2:
+	default:
+		return KeyDerivationError("unknown password kdf")
+	}
+
+	return nil
+}
+
+// derive stretches the password into a key.
+func (p PasswordParams) derive(password []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	switch p.KDF {
+	case KDFScrypt:
+		key, err := scrypt.Key(password, p.Salt, int(p.Cost), int(p.Memory), int(p.Parallelism), passwordKeySize)
+		if err != nil {
+			return nil, fmt.Errorf("%w:%w", KeyDerivationError("error deriving key"), err)
+		}
+		return key, nil
+	case KDFArgon2id:
+		return argon2.IDKey(password, p.Salt, p.Cost, p.Memory, p.Parallelism, passwordKeySize), nil
+	default:
+		return pbkdf2.Key(password, p.Salt, int(p.Cost), passwordKeySize, sha256.New), nil
+	}
+}
+
+// marshal encodes the parameters for the package header.
+func (p PasswordParams) marshal() []byte {
+	dst := make([]byte, 0, passwordParamsFixedLen+len(p.Salt))
+	dst = append(dst, byte(p.KDF))
+	dst = binary.BigEndian.AppendUint32(dst, p.Cost)
+	dst = binary.BigEndian.AppendUint32(dst, p.Memory)
+	dst = append(dst, p.Parallelism, byte(len(p.Salt)))
+
+	return append(dst, p.Salt...)
+}
+
+// parsePasswordParams decodes parameters from a package header.
+func parsePasswordParams(encoded []byte) (PasswordParams, error) {
+	if len(encoded) < passwordParamsFixedLen || len(encoded) != passwordParamsFixedLen+int(encoded[10]) {
+		return PasswordParams{}, CipherTextError("password parameters invalid")
+	}
+	p := PasswordParams{
+		KDF:         PasswordKDF(encoded[0]),
+		Cost:        binary.BigEndian.Uint32(encoded[1:5]),
+		Memory:      binary.BigEndian.Uint32(encoded[5:9]),
+		Parallelism: encoded[9],
+		Salt:        encoded[passwordParamsFixedLen:],
+	}
+
+	return p, p.validate()
+}
+
+// NewPasswordEncryptor creates an Encryptor with a key derived from a password.
+// The key is derived once, all packages of the encryptor share the salt of params.
+// Every package carries the parameters in its header, the password is all that is needed to decrypt it.
+// algorithm selects the cryptor sealing the packages, AlgorithmCBCHMAC or AlgorithmGCM.
+func NewPasswordEncryptor(password []byte, params PasswordParams, algorithm Algorithm) (Encryptor, error) {
+	return NewPasswordEncryptorWithRand(password, params, algorithm, rand.Reader)
+}
+
+// NewPasswordEncryptorWithRand is NewPasswordEncryptor drawing the salt, nonces & IVs from rand.
+// Use a deterministic source for reproducible packages in tests only.
+func NewPasswordEncryptorWithRand(password []byte, params PasswordParams, algorithm Algorithm, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	if len(password) == 0 {
+		return nil, EncryptionKeyError("password must not be empty")
+	}
+	if len(params.Salt) == 0 {
+		params.Salt = make([]byte, passwordSaltSize)
+		if _, err := io.ReadFull(rand, params.Salt); err != nil {
+			return nil, err
+		}
+	}
+	key, err := params.derive(password)
+	if err != nil {
+		return nil, err
+	}
+	encoded := params.marshal()
+
+	switch algorithm {
+	case AlgorithmCBCHMAC:
+		encryptionKey, integrityKey, err := DeriveCBCHMACKeys(key, nil, passwordKeyContext, sha256.New)
+		if err != nil {
+			return nil, err
+		}
+		cryptor, err := newCBCHMACryptor(encryptionKey, integrityKey, sha256.New)
+		if err != nil {
+			return nil, err
+		}
+		cryptor.rand = rand
+		cryptor.parameters = encoded
+		return (encryptorCBCHMAC)(cryptor), nil
+	case AlgorithmGCM:
+		cryptor, err := newGCMCryptor(key)
+		if err != nil {
+			return nil, err
+		}
+		cryptor.useRandomNonces(rand)
+		cryptor.parameters = encoded
+		return (encryptorGCM)(cryptor), nil
+	}
+
+	return nil, InvalidUsageError("password encryption supports " + AlgorithmCBCHMAC.String() + " and " + AlgorithmGCM.String())
+}
+
+// NewPasswordDecryptor creates a Decryptor deriving the key from a password and the parameters in the package header.
+// The key of the last parameters seen is cached, packages sharing a salt are only derived once.
+func NewPasswordDecryptor(password []byte) (Decryptor, error) {
+	if len(password) == 0 {
+		return nil, EncryptionKeyError("password must not be empty")
+	}
+	copied := make([]byte, len(password))
+	copy(copied, password)
+
+	return &passwordDecryptor{password: copied}, nil
+}
+
+// Password decryption mode.
+type passwordDecryptor struct {
+	password []byte
+
+	mu         sync.Mutex
+	lastParams string
+	lastCBC    Decryptor
+	lastGCM    Decryptor
+}
+
+// Crypt decrypts a package sealed by a password Encryptor.
+func (d *passwordDecryptor) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	header, _, err := ParseHeader(cipherpackage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Algorithm != AlgorithmCBCHMAC && header.Algorithm != AlgorithmGCM {
+		return nil, nil, CipherTextError("package was not sealed with a password")
+	}
+	cbc, gcm, err := d.decryptors(header.Parameters)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Algorithm == AlgorithmCBCHMAC {
+		return cbc.Crypt(cipherpackage)
+	}
+
+	return gcm.Crypt(cipherpackage)
+}
+
+// decryptors returns the cryptors for the key derived from the given parameters.
+func (d *passwordDecryptor) decryptors(encoded []byte) (Decryptor, Decryptor, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.lastCBC != nil && d.lastParams == string(encoded) {
+		return d.lastCBC, d.lastGCM, nil
+	}
+	params, err := parsePasswordParams(encoded)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := params.derive(d.password)
+	if err != nil {
+		return nil, nil, err
+	}
+	encryptionKey, integrityKey, err := DeriveCBCHMACKeys(key, nil, passwordKeyContext, sha256.New)
+	if err != nil {
+		return nil, nil, err
+	}
+	cbc, err := NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := NewGCMDecryptor(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	d.lastParams, d.lastCBC, d.lastGCM = string(encoded), cbc, gcm
+
+	return cbc, gcm, nil
+}
+
+// CalibratePassword picks parameters for a KDF so that deriving a key takes about the target duration on this machine.
+// The cost is doubled until the target is reached, the memory of the default parameters is kept.
+// The cost stops at the largest value within the limits decryptors accept, even if the target isn't reached.
+func CalibratePassword(kdf PasswordKDF, target time.Duration) (PasswordParams, error) {
+	params := DefaultPasswordParams(kdf)
+	if params.KDF == 0 {
+		return PasswordParams{}, KeyDerivationError("unknown password kdf")
+	}
+	// Start low and double.
+	switch kdf {
+	case KDFScrypt:
+		params.Cost = 1 << 12
+	case KDFArgon2id:
+		params.Cost = 1
+	case KDFPBKDF2:
+		params.Cost = 10000
+	}
+	params.Salt = make([]byte, passwordSaltSize)
+	password := []byte("calibration")
+	for {
+		start := time.Now()
+		if _, err := params.derive(password); err != nil {
+			return PasswordParams{}, err
+		}
+		elapsed := time.Since(start)
+		if elapsed >= target {
+			break
+		}
+		next := params
+		next.Cost *= 2
+		if next.validate() != nil {
+			break
+		}
+		params = next
+	}
+	params.Salt = nil
+
+	return params, nil
+}
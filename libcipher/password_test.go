@@ -0,0 +1,155 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestPassword_EncryptDecrypt(t *testing.T) {
+	// Cheap parameters keep the test fast, never use them for real data.
+	var testCases = []struct {
+		name      string
+		params    libcipher.PasswordParams
+		algorithm libcipher.Algorithm
+	}{
+		{name: "ScryptCBCHMAC", params: libcipher.PasswordParams{KDF: libcipher.KDFScrypt, Cost: 1024, Memory: 8, Parallelism: 1}, algorithm: libcipher.AlgorithmCBCHMAC},
+		{name: "Argon2idGCM", params: libcipher.PasswordParams{KDF: libcipher.KDFArgon2id, Cost: 1, Memory: 1024, Parallelism: 1}, algorithm: libcipher.AlgorithmGCM},
+		{name: "PBKDF2CBCHMAC", params: libcipher.PasswordParams{KDF: libcipher.KDFPBKDF2, Cost: 1000}, algorithm: libcipher.AlgorithmCBCHMAC},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encryptor, err := libcipher.NewPasswordEncryptor([]byte("correct horse battery staple"), tc.params, tc.algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, _, err := libcipher.ParseHeader(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Algorithm != tc.algorithm || len(header.Parameters) == 0 {
+				t.Fatalf("unexpected header %+v", header)
+			}
+
+			decryptor, err := libcipher.NewPasswordDecryptor([]byte("correct horse battery staple"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, ad, err := decryptor.Crypt(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, []byte("message")) || !bytes.Equal(ad, []byte("ad")) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+			}
+
+			wrong, err := libcipher.NewPasswordDecryptor([]byte("wrong password"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := wrong.Crypt(cipherpackage); err == nil {
+				t.Fatal("expected an error decrypting with a wrong password")
+			}
+		})
+	}
+}
+
+func TestPassword_InvalidParams(t *testing.T) {
+	var testCases = []struct {
+		name   string
+		params libcipher.PasswordParams
+	}{
+		{name: "ScryptCostNotPowerOfTwo", params: libcipher.PasswordParams{KDF: libcipher.KDFScrypt, Cost: 1000, Memory: 8, Parallelism: 1}},
+		{name: "Argon2idNoMemory", params: libcipher.PasswordParams{KDF: libcipher.KDFArgon2id, Cost: 1, Parallelism: 1}},
+		{name: "ZeroCost", params: libcipher.PasswordParams{KDF: libcipher.KDFPBKDF2}},
+		{name: "UnknownKDF", params: libcipher.PasswordParams{KDF: 42, Cost: 1}},
+		{name: "ShortSalt", params: libcipher.PasswordParams{KDF: libcipher.KDFPBKDF2, Cost: 1000, Salt: []byte("salt")}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := libcipher.NewPasswordEncryptor([]byte("password"), tc.params, libcipher.AlgorithmGCM); err == nil {
+				t.Fatal("expected an error for invalid parameters")
+			}
+		})
+	}
+}
+
+func TestPassword_OversizedHeader(t *testing.T) {
+	encryptor, err := libcipher.NewPasswordEncryptor([]byte("password"), libcipher.PasswordParams{KDF: libcipher.KDFScrypt, Cost: 1024, Memory: 8, Parallelism: 1}, libcipher.AlgorithmGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, headerLength, err := libcipher.ParseHeader(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewPasswordDecryptor([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Parameters: [ KDF | Cost (4 bytes) | Memory (4 bytes) | Parallelism | Salt-Length | Salt ]
+	var testCases = []struct {
+		name     string
+		params   []byte
+		expected string
+	}{
+		{name: "ScryptParallelism", params: []byte{1, 0, 0, 0x40, 0, 0, 0, 0, 8, 255}, expected: "libcipher/cipher: scrypt parameters exceed the work limit"},
+		{name: "ScryptCost", params: []byte{1, 0, 0x10, 0, 0, 0, 0, 0, 8, 1}, expected: "libcipher/cipher: scrypt parameters exceed the work limit"},
+		{name: "Argon2idPasses", params: []byte{2, 0, 0, 0x10, 0, 0, 0, 0x10, 0, 4}, expected: "libcipher/cipher: argon2id parameters exceed the work limit"},
+		{name: "Argon2idMemory", params: []byte{2, 0, 0, 0, 1, 0, 0x40, 0, 0, 255}, expected: "libcipher/cipher: argon2id parameters invalid"},
+		{name: "PBKDF2Iterations", params: []byte{3, 0, 0x40, 0, 0, 0, 0, 0, 0, 0}, expected: "libcipher/cipher: password cost out of range"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Keep the salt of the package, only the cost parameters are crafted.
+			crafted := header
+			crafted.Parameters = append(tc.params, header.Parameters[10:]...)
+			encoded, err := crafted.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = decryptor.Crypt(append(encoded, cipherpackage[headerLength:]...))
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("expected %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestPassword_Calibrate(t *testing.T) {
+	params, err := libcipher.CalibratePassword(libcipher.KDFPBKDF2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.KDF != libcipher.KDFPBKDF2 || params.Cost < 10000 || params.Salt != nil {
+		t.Fatalf("unexpected parameters %+v", params)
+	}
+}
+
+func TestPassword_CalibrateCeiling(t *testing.T) {
+	if testing.Short() {
+		t.Skip("derives keys up to the scrypt work limit")
+	}
+	// The target is never reached, calibration has to stop at the work limit.
+	params, err := libcipher.CalibratePassword(libcipher.KDFScrypt, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Cost != 1<<18 || params.Memory != 8 || params.Parallelism != 1 {
+		t.Fatalf("unexpected parameters %+v", params)
+	}
+	if _, err := libcipher.NewPasswordEncryptor([]byte("password"), params, libcipher.AlgorithmGCM); err != nil {
+		t.Fatalf("calibrated parameters are invalid: %v", err)
+	}
+}
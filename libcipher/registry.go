@@ -0,0 +1,201 @@
+package libcipher
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// AlgorithmSpec describes how the cryptors of a registered algorithm are constructed.
+type AlgorithmSpec struct {
+	// KeySizes are the sizes of the keys the constructors expect, in order.
+	KeySizes []int
+	// NewEncryptor creates an Encryptor from keys matching KeySizes, nonces & IVs are drawn from rand.
+	NewEncryptor func(keys [][]byte, rand io.Reader) (Encryptor, error)
+	// NewDecryptor creates a Decryptor from keys matching KeySizes.
+	NewDecryptor func(keys [][]byte) (Decryptor, error)
+}
+
+// registry holds the algorithms available to NewEncryptor & NewDecryptor by name.
+var registry = struct {
+	mu    sync.RWMutex
+	specs map[string]AlgorithmSpec
+}{specs: map[string]AlgorithmSpec{
+	"aes-128-cbc-hmac-sha256": cbcHMACSpec(16, 32, sha256.New),
+	"aes-256-cbc-hmac-sha256": cbcHMACSpec(32, 32, sha256.New),
+	"aes-256-cbc-hmac-sha512": cbcHMACSpec(32, 64, sha512.New),
+	"aes-128-gcm":             gcmSpec(16),
+	"aes-256-gcm":             gcmSpec(32),
+	"aes-128-gcm-committing":  committingGCMSpec(16),
+	"aes-256-gcm-committing":  committingGCMSpec(32),
+	"xchacha20-poly1305": {
+		KeySizes:     []int{32},
+		NewEncryptor: func(keys [][]byte, rand io.Reader) (Encryptor, error) { return NewXChaChaEncryptor(keys[0], rand) },
+		NewDecryptor: func(keys [][]byte) (Decryptor, error) { return NewXChaChaDecryptor(keys[0]) },
+	},
+	"aes-256-siv": {
+		KeySizes:     []int{64},
+		NewEncryptor: func(keys [][]byte, rand io.Reader) (Encryptor, error) { return NewSIVEncryptor(keys[0], rand) },
+		NewDecryptor: func(keys [][]byte) (Decryptor, error) { return NewSIVDecryptor(keys[0]) },
+	},
+	"aes-256-siv-deterministic": {
+		KeySizes:     []int{64},
+		NewEncryptor: func(keys [][]byte, _ io.Reader) (Encryptor, error) { return NewDeterministicEncryptor(keys[0]) },
+		NewDecryptor: func(keys [][]byte) (Decryptor, error) { return NewDeterministicDecryptor(keys[0]) },
+	},
+}}
+
+// Register makes an algorithm available to NewEncryptor & NewDecryptor under name.
+// It returns an error if the name is already taken, built-in algorithms can't be replaced.
+func Register(name string, spec AlgorithmSpec) error {
+	if len(name) == 0 {
+		return InvalidUsageError("algorithm name must not be empty")
+	}
+	if len(spec.KeySizes) == 0 || spec.NewEncryptor == nil || spec.NewDecryptor == nil {
+		return InvalidUsageError("algorithm spec is incomplete")
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if _, ok := registry.specs[name]; ok {
+		return InvalidUsageError(fmt.Sprintf("algorithm %s already registered", name))
+	}
+	registry.specs[name] = AlgorithmSpec{
+		KeySizes:     append([]int(nil), spec.KeySizes...),
+		NewEncryptor: spec.NewEncryptor,
+		NewDecryptor: spec.NewDecryptor,
+	}
+
+	return nil
+}
+
+// Algorithms returns the names of all registered algorithms in sorted order.
+func Algorithms() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	names := make([]string, 0, len(registry.specs))
+	for name := range registry.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// LookupAlgorithm returns the spec registered under name.
+func LookupAlgorithm(name string) (AlgorithmSpec, error) {
+	registry.mu.RLock()
+	spec, ok := registry.specs[name]
+	registry.mu.RUnlock()
+	if !ok {
+		return AlgorithmSpec{}, InvalidUsageError(fmt.Sprintf("unknown algorithm %s", name))
+	}
+
+	return spec, nil
+}
+
+// NewEncryptor creates an Encryptor for the algorithm registered under name, e.g. "aes-256-gcm".
+// The keys have to match the key sizes of the algorithm, nonces & IVs are drawn from crypto/rand.
+func NewEncryptor(name string, keys ...[]byte) (Encryptor, error) {
+	return NewEncryptorWithRand(name, rand.Reader, keys...)
+}
+
+// NewEncryptorWithRand is NewEncryptor drawing nonces & IVs from rand.
+func NewEncryptorWithRand(name string, rand io.Reader, keys ...[]byte) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	spec, err := lookupKeys(name, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return spec.NewEncryptor(keys, rand)
+}
+
+// NewDecryptor creates a Decryptor for the algorithm registered under name.
+// The keys have to match the key sizes of the algorithm.
+func NewDecryptor(name string, keys ...[]byte) (Decryptor, error) {
+	spec, err := lookupKeys(name, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return spec.NewDecryptor(keys)
+}
+
+// DeriveAlgorithmKeys derives all keys of the algorithm registered under name from a master secret using HKDF-SHA256.
+// Every key is bound to the context, the algorithm name and its position.
+func DeriveAlgorithmKeys(name string, masterKey []byte, salt []byte, context string) ([][]byte, error) {
+	spec, err := LookupAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, len(spec.KeySizes))
+	for i, size := range spec.KeySizes {
+		keys[i], err = DeriveKey(masterKey, salt, context+"/"+name+"/"+strconv.Itoa(i), size, sha256.New)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// lookupKeys returns the spec registered under name after checking the keys against its key sizes.
+func lookupKeys(name string, keys [][]byte) (AlgorithmSpec, error) {
+	spec, err := LookupAlgorithm(name)
+	if err != nil {
+		return AlgorithmSpec{}, err
+	}
+	if len(keys) != len(spec.KeySizes) {
+		return AlgorithmSpec{}, InvalidUsageError(fmt.Sprintf("%s expects %d keys, got %d", name, len(spec.KeySizes), len(keys)))
+	}
+	for i, size := range spec.KeySizes {
+		if len(keys[i]) != size {
+			return AlgorithmSpec{}, EncryptionKeyError(fmt.Sprintf("%s key %d must be %d bytes", name, i, size))
+		}
+	}
+
+	return spec, nil
+}
+
+// cbcHMACSpec describes AES-CBC+HMAC with the given key sizes and hash.
+func cbcHMACSpec(encryptionKeySize int, integrityKeySize int, calculateMAC func() hash.Hash) AlgorithmSpec {
+	return AlgorithmSpec{
+		KeySizes: []int{encryptionKeySize, integrityKeySize},
+		NewEncryptor: func(keys [][]byte, rand io.Reader) (Encryptor, error) {
+			return NewCBCHMACEncryptorWithRand(keys[0], keys[1], calculateMAC, rand)
+		},
+		NewDecryptor: func(keys [][]byte) (Decryptor, error) {
+			return NewCBCHMACDecryptor(keys[0], keys[1], calculateMAC)
+		},
+	}
+}
+
+// gcmSpec describes AES-GCM with the given key size.
+func gcmSpec(keySize int) AlgorithmSpec {
+	return AlgorithmSpec{
+		KeySizes:     []int{keySize},
+		NewEncryptor: func(keys [][]byte, rand io.Reader) (Encryptor, error) { return NewGCMEncryptor(keys[0], rand) },
+		NewDecryptor: func(keys [][]byte) (Decryptor, error) { return NewGCMDecryptor(keys[0]) },
+	}
+}
+
+// committingGCMSpec describes key-committing AES-GCM with the given key size.
+func committingGCMSpec(keySize int) AlgorithmSpec {
+	return AlgorithmSpec{
+		KeySizes: []int{keySize},
+		NewEncryptor: func(keys [][]byte, rand io.Reader) (Encryptor, error) {
+			return NewCommittingGCMEncryptor(keys[0], rand)
+		},
+		NewDecryptor: func(keys [][]byte) (Decryptor, error) { return NewCommittingGCMDecryptor(keys[0]) },
+	}
+}
@@ -0,0 +1,106 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestRegistry_EncryptDecrypt(t *testing.T) {
+	master := []byte("a master key of at least 16 bytes")
+	for _, name := range libcipher.Algorithms() {
+		t.Run(name, func(t *testing.T) {
+			keys, err := libcipher.DeriveAlgorithmKeys(name, master, nil, "registry-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			encryptor, err := libcipher.NewEncryptor(name, keys...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decryptor, err := libcipher.NewDecryptor(name, keys...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, ad, err := decryptor.Crypt(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, []byte("message")) || !bytes.Equal(ad, []byte("ad")) {
+				t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+			}
+		})
+	}
+}
+
+func TestRegistry_InvalidKeys(t *testing.T) {
+	var testCases = []struct {
+		name string
+		alg  string
+		keys [][]byte
+	}{
+		{name: "UnknownAlgorithm", alg: "rot13", keys: [][]byte{make([]byte, 32)}},
+		{name: "MissingKey", alg: "aes-256-cbc-hmac-sha256", keys: [][]byte{make([]byte, 32)}},
+		{name: "WrongKeySize", alg: "aes-256-gcm", keys: [][]byte{make([]byte, 16)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := libcipher.NewEncryptor(tc.alg, tc.keys...); err == nil {
+				t.Fatal("expected an error creating the encryptor")
+			}
+			if _, err := libcipher.NewDecryptor(tc.alg, tc.keys...); err == nil {
+				t.Fatal("expected an error creating the decryptor")
+			}
+		})
+	}
+}
+
+// nullEncryptor & nullDecryptor are a test algorithm which doesn't encrypt at all.
+type nullEncryptor struct{}
+type nullDecryptor struct{}
+
+func (nullEncryptor) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	return append([]byte{}, message...), nil
+}
+
+func (nullDecryptor) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	return append([]byte{}, cipherpackage...), []byte("ad"), nil
+}
+
+func TestRegistry_Register(t *testing.T) {
+	spec := libcipher.AlgorithmSpec{
+		KeySizes: []int{1},
+		NewEncryptor: func(keys [][]byte, rand io.Reader) (libcipher.Encryptor, error) {
+			return nullEncryptor{}, nil
+		},
+		NewDecryptor: func(keys [][]byte) (libcipher.Decryptor, error) {
+			return nullDecryptor{}, nil
+		},
+	}
+	// The registry is global, a unique name keeps the test repeatable.
+	name := fmt.Sprintf("null-test-%d", time.Now().UnixNano())
+	if err := libcipher.Register(name, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := libcipher.Register(name, spec); err == nil {
+		t.Fatal("expected an error registering a name twice")
+	}
+	if err := libcipher.Register("aes-256-gcm", spec); err == nil {
+		t.Fatal("expected an error replacing a built-in algorithm")
+	}
+	encryptor, err := libcipher.NewEncryptor(name, []byte{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := encryptor.Crypt([]byte("message"), nil); err != nil || string(out) != "message" {
+		t.Fatalf("unexpected result %q: %v", out, err)
+	}
+}
@@ -0,0 +1,150 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+// sealOpenCase builds an encryptor & decryptor pair implementing Sealer & Opener.
+type sealOpenCase struct {
+	name string
+	new  func(t testing.TB) (libcipher.Encryptor, libcipher.Decryptor)
+}
+
+var sealOpenCases = []sealOpenCase{
+	{
+		name: "CBCHMAC",
+		new: func(t testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			encryptionKey, integrityKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+			encryptor, err := libcipher.NewCBCHMACEncryptor(encryptionKey, integrityKey, sha256.New)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return encryptor, decryptor
+		},
+	},
+	{
+		name: "GCM",
+		new: func(t testing.TB) (libcipher.Encryptor, libcipher.Decryptor) {
+			key := bytes.Repeat([]byte{3}, 32)
+			encryptor, err := libcipher.NewGCMEncryptor(key, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decryptor, err := libcipher.NewGCMDecryptor(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return encryptor, decryptor
+		},
+	},
+}
+
+func TestSealOpen(t *testing.T) {
+	for _, tc := range sealOpenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encryptor, decryptor := tc.new(t)
+			sealer, ok := encryptor.(libcipher.Sealer)
+			if !ok {
+				t.Fatal("encryptor does not implement Sealer")
+			}
+			opener, ok := decryptor.(libcipher.Opener)
+			if !ok {
+				t.Fatal("decryptor does not implement Opener")
+			}
+			message := []byte("This is some super secret data to encrypt.")
+			additionalData := []byte("additional data")
+
+			// Packages are appended to dst & readable by Crypt.
+			prefix := []byte("prefix")
+			sealed, err := sealer.Seal(append([]byte(nil), prefix...), message, additionalData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(sealed, prefix) {
+				t.Fatal("Seal did not append to dst")
+			}
+			cipherpackage := sealed[len(prefix):]
+			decrypted, ad, err := decryptor.Crypt(cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, message) || !bytes.Equal(ad, additionalData) {
+				t.Fatal("Crypt did not decrypt the sealed package")
+			}
+
+			// Messages are appended to dst & packages of Crypt are readable by Open.
+			cipherpackage, err = encryptor.Crypt(message, additionalData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened, ad, err := opener.Open(append([]byte(nil), prefix...), cipherpackage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(opened, append(append([]byte(nil), prefix...), message...)) || !bytes.Equal(ad, additionalData) {
+				t.Fatal("Open did not append the message to dst")
+			}
+
+			// Tampered packages are rejected.
+			cipherpackage[len(cipherpackage)-1] ^= 1
+			if _, _, err := opener.Open(nil, cipherpackage); err == nil {
+				t.Fatal("Open accepted a tampered package")
+			}
+		})
+	}
+}
+
+func BenchmarkCrypt(b *testing.B) {
+	message := make([]byte, 1024)
+	additionalData := []byte("additional data")
+	for _, tc := range sealOpenCases {
+		b.Run(tc.name, func(b *testing.B) {
+			encryptor, decryptor := tc.new(b)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(message)))
+			for i := 0; i < b.N; i++ {
+				cipherpackage, err := encryptor.Crypt(message, additionalData)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, _, err := decryptor.Crypt(cipherpackage); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSealOpen(b *testing.B) {
+	message := make([]byte, 1024)
+	additionalData := []byte("additional data")
+	for _, tc := range sealOpenCases {
+		b.Run(tc.name, func(b *testing.B) {
+			encryptor, decryptor := tc.new(b)
+			sealer, opener := encryptor.(libcipher.Sealer), decryptor.(libcipher.Opener)
+			var cipherpackage, plaintext []byte
+			b.ReportAllocs()
+			b.SetBytes(int64(len(message)))
+			for i := 0; i < b.N; i++ {
+				var err error
+				cipherpackage, err = sealer.Seal(cipherpackage[:0], message, additionalData)
+				if err != nil {
+					b.Fatal(err)
+				}
+				plaintext, _, err = opener.Open(plaintext[:0], cipherpackage)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package shamir
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1.
+// The operations don't branch on or index by their operands, so shares & secrets aren't leaked through timing.
+
+// add adds (and subtracts) two field elements.
+func add(a byte, b byte) byte {
+	return a ^ b
+}
+
+// mul multiplies two field elements.
+func mul(a byte, b byte) byte {
+	var product byte
+	for i := 0; i < 8; i++ {
+		// mask is 0xff if the lowest bit of b is set.
+		mask := -(b & 1)
+		product ^= a & mask
+		// Multiply a by x, reducing by the polynomial if the highest bit overflows.
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+
+	return product
+}
+
+// inv returns the multiplicative inverse a^254 of a non-zero field element.
+func inv(a byte) byte {
+	// a^254 = a^(2+4+8+16+32+64+128).
+	result := byte(1)
+	square := a
+	for i := 0; i < 7; i++ {
+		square = mul(square, square)
+		result = mul(result, square)
+	}
+
+	return result
+}
+
+// evaluate evaluates the polynomial with the coefficients, lowest degree first, at x.
+func evaluate(coefficients []byte, x byte) byte {
+	var result byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		result = add(mul(result, x), coefficients[i])
+	}
+
+	return result
+}
+
+// interpolate evaluates the polynomial through the points (xs[i], ys[i]) at 0.
+// The xs have to be distinct & non-zero.
+func interpolate(xs []byte, ys []byte) byte {
+	var result byte
+	for i := range xs {
+		// Lagrange basis polynomial of xs[i] at 0: the product of xs[j] / (xs[j] - xs[i]).
+		basis := byte(1)
+		for j := range xs {
+			if i == j {
+				continue
+			}
+			basis = mul(basis, mul(xs[j], inv(add(xs[j], xs[i]))))
+		}
+		result = add(result, mul(ys[i], basis))
+	}
+
+	return result
+}
@@ -0,0 +1,223 @@
+// Package shamir splits secrets into shares with Shamir's secret sharing over GF(256).
+// Any threshold of the shares recombine to the secret, fewer reveal nothing about it.
+package shamir
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type (
+	ShareError string
+)
+
+func (e ShareError) Error() string {
+	return "libcipher/shamir: " + (string)(e)
+}
+
+const (
+	// MaxShares is the largest number of shares, every share needs a distinct non-zero x coordinate in GF(256).
+	MaxShares = 255
+
+	shareVersion   = 1
+	sharePrefix    = "u8share:"
+	setIDSize      = 4
+	checksumSize   = 4
+	shareFixedSize = 1 + setIDSize + 1 + 1
+)
+
+// Share is one share of a split secret.
+//
+//	The encoded share format:
+//	[ Version | Set ID (4 bytes) | Threshold | Index | Value | Checksum (4 bytes) ]
+//
+// The set ID is random per split, shares of different splits can't be combined by accident.
+// The checksum is the truncated SHA-256 of the preceding bytes, it detects typos & corruption, not forgery.
+type Share struct {
+	SetID     uint32
+	Threshold byte
+	// Index is the x coordinate of the share, 1 to MaxShares.
+	Index byte
+	// Value holds one byte per byte of the secret.
+	Value []byte
+}
+
+// Split splits the secret into n shares, any threshold of them recombine to the secret.
+// The polynomial coefficients & the set ID are drawn from rand, e.g. crypto/rand.Reader.
+func Split(secret []byte, threshold int, n int, rand io.Reader) ([]Share, error) {
+	if rand == nil {
+		return nil, ShareError("rand was nil")
+	}
+	if len(secret) == 0 {
+		return nil, ShareError("secret must not be empty")
+	}
+	if threshold < 2 {
+		return nil, ShareError("threshold must be at least 2")
+	}
+	if n < threshold {
+		return nil, ShareError("number of shares must not be smaller than the threshold")
+	}
+	if n > MaxShares {
+		return nil, ShareError(fmt.Sprintf("number of shares must not exceed %d", MaxShares))
+	}
+
+	var setID [setIDSize]byte
+	if _, err := io.ReadFull(rand, setID[:]); err != nil {
+		return nil, err
+	}
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{
+			SetID:     binary.BigEndian.Uint32(setID[:]),
+			Threshold: byte(threshold),
+			Index:     byte(i + 1),
+			Value:     make([]byte, len(secret)),
+		}
+	}
+
+	// Every byte of the secret is the constant term of its own random polynomial of degree threshold-1.
+	coefficients := make([]byte, threshold)
+	defer clear(coefficients)
+	for position, b := range secret {
+		coefficients[0] = b
+		if _, err := io.ReadFull(rand, coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i].Value[position] = evaluate(coefficients, shares[i].Index)
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine recombines the secret from at least threshold shares of the same split.
+// Shares of another split, duplicates & shares of differing length are rejected.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ShareError("no shares")
+	}
+	first := shares[0]
+	if first.Threshold < 2 {
+		return nil, ShareError("threshold must be at least 2")
+	}
+	if len(shares) < int(first.Threshold) {
+		return nil, ShareError(fmt.Sprintf("%d shares are required, got %d", first.Threshold, len(shares)))
+	}
+	if len(first.Value) == 0 {
+		return nil, ShareError("share value must not be empty")
+	}
+
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if share.SetID != first.SetID {
+			return nil, ShareError("shares belong to different splits")
+		}
+		if share.Threshold != first.Threshold {
+			return nil, ShareError("shares have different thresholds")
+		}
+		if len(share.Value) != len(first.Value) {
+			return nil, ShareError("shares have different lengths")
+		}
+		if share.Index == 0 {
+			return nil, ShareError("share index must not be 0")
+		}
+		if seen[share.Index] {
+			return nil, ShareError(fmt.Sprintf("share %d was given twice", share.Index))
+		}
+		seen[share.Index] = true
+		xs[i] = share.Index
+	}
+
+	secret := make([]byte, len(first.Value))
+	ys := make([]byte, len(shares))
+	defer clear(ys)
+	for position := range secret {
+		for i, share := range shares {
+			ys[i] = share.Value[position]
+		}
+		secret[position] = interpolate(xs, ys)
+	}
+
+	return secret, nil
+}
+
+// MarshalBinary encodes the share with its checksum.
+func (s Share) MarshalBinary() ([]byte, error) {
+	encoded := make([]byte, 0, shareFixedSize+len(s.Value)+checksumSize)
+	encoded = append(encoded, shareVersion)
+	encoded = binary.BigEndian.AppendUint32(encoded, s.SetID)
+	encoded = append(encoded, s.Threshold, s.Index)
+	encoded = append(encoded, s.Value...)
+
+	return append(encoded, checksum(encoded)...), nil
+}
+
+// UnmarshalBinary decodes a share encoded by MarshalBinary after verifying its checksum.
+func (s *Share) UnmarshalBinary(encoded []byte) error {
+	if len(encoded) < shareFixedSize+1+checksumSize {
+		return ShareError("share is too short")
+	}
+	payload, sum := encoded[:len(encoded)-checksumSize], encoded[len(encoded)-checksumSize:]
+	if subtle.ConstantTimeCompare(checksum(payload), sum) != 1 {
+		return ShareError("share checksum mismatch")
+	}
+	if payload[0] != shareVersion {
+		return ShareError(fmt.Sprintf("unsupported share version %d", payload[0]))
+	}
+	share := Share{
+		SetID:     binary.BigEndian.Uint32(payload[1 : 1+setIDSize]),
+		Threshold: payload[1+setIDSize],
+		Index:     payload[2+setIDSize],
+		Value:     bytes.Clone(payload[shareFixedSize:]),
+	}
+	if share.Threshold < 2 {
+		return ShareError("threshold must be at least 2")
+	}
+	if share.Index == 0 {
+		return ShareError("share index must not be 0")
+	}
+	*s = share
+
+	return nil
+}
+
+// Format encodes a share as "u8share:" followed by the unpadded base64url encoded share.
+// The result is secret and must be stored like any other key.
+func Format(share Share) string {
+	encoded, _ := share.MarshalBinary()
+
+	return sharePrefix + base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// Parse decodes a share encoded by Format, surrounding whitespace is ignored.
+func Parse(encoded string) (Share, error) {
+	encoded = strings.TrimSpace(encoded)
+	if !strings.HasPrefix(encoded, sharePrefix) {
+		return Share{}, ShareError("share must start with " + sharePrefix)
+	}
+	decoded, err := base64.RawURLEncoding.Strict().DecodeString(encoded[len(sharePrefix):])
+	if err != nil {
+		return Share{}, fmt.Errorf("%w: %w", ShareError("share is not valid base64url"), err)
+	}
+	var share Share
+	if err := share.UnmarshalBinary(decoded); err != nil {
+		return Share{}, err
+	}
+
+	return share, nil
+}
+
+// checksum returns the truncated SHA-256 of the encoded share.
+func checksum(encoded []byte) []byte {
+	sum := sha256.Sum256(encoded)
+
+	return sum[:checksumSize]
+}
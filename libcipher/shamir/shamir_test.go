@@ -0,0 +1,205 @@
+package shamir_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher/shamir"
+)
+
+// subsets returns all subsets of the shares with exactly k elements.
+func subsets(shares []shamir.Share, k int) [][]shamir.Share {
+	if k == 0 {
+		return [][]shamir.Share{{}}
+	}
+	var result [][]shamir.Share
+	for i := 0; i <= len(shares)-k; i++ {
+		for _, rest := range subsets(shares[i+1:], k-1) {
+			result = append(result, append([]shamir.Share{shares[i]}, rest...))
+		}
+	}
+
+	return result
+}
+
+func TestSplitCombine(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		n         int
+	}{
+		{"2-of-2", 2, 2},
+		{"2-of-3", 2, 3},
+		{"3-of-5", 3, 5},
+		{"5-of-5", 5, 5},
+		{"4-of-10", 4, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := make([]byte, 64)
+			if _, err := rand.Read(secret); err != nil {
+				t.Fatal(err)
+			}
+			shares, err := shamir.Split(secret, tt.threshold, tt.n, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(shares) != tt.n {
+				t.Fatalf("got %d shares", len(shares))
+			}
+			// Every quorum and every larger set recombines the secret.
+			for k := tt.threshold; k <= tt.n; k++ {
+				for _, subset := range subsets(shares, k) {
+					combined, err := shamir.Combine(subset)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(combined, secret) {
+						t.Fatalf("%d shares recombined to %x", k, combined)
+					}
+				}
+			}
+			// Fewer shares are rejected.
+			if _, err := shamir.Combine(shares[:tt.threshold-1]); err == nil {
+				t.Fatal("combined fewer shares than the threshold")
+			}
+		})
+	}
+}
+
+func TestSplitKnownAnswer(t *testing.T) {
+	// Set ID, then one coefficient per secret byte: f(x) = 0x42 + x and f(x) = 0x00 + 0x53x.
+	random := bytes.NewReader([]byte{0, 0, 0, 7, 0x01, 0x53})
+	shares, err := shamir.Split([]byte{0x42, 0x00}, 2, 3, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0x53 * 0xca = 1 in the AES field, the products below follow from it.
+	expected := [][]byte{{0x43, 0x53}, {0x40, 0xa6}, {0x41, 0xf5}}
+	for i, share := range shares {
+		if share.SetID != 7 || share.Threshold != 2 || share.Index != byte(i+1) {
+			t.Fatalf("share %d: %+v", i, share)
+		}
+		if !bytes.Equal(share.Value, expected[i]) {
+			t.Fatalf("share %d: got %x, want %x", i, share.Value, expected[i])
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		threshold int
+		n         int
+		expected  string
+	}{
+		{"EmptySecret", nil, 2, 3, "libcipher/shamir: secret must not be empty"},
+		{"ThresholdOne", []byte("secret"), 1, 3, "libcipher/shamir: threshold must be at least 2"},
+		{"FewerShares", []byte("secret"), 3, 2, "libcipher/shamir: number of shares must not be smaller than the threshold"},
+		{"TooManyShares", []byte("secret"), 2, 256, "libcipher/shamir: number of shares must not exceed 255"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := shamir.Split(tt.secret, tt.threshold, tt.n, rand.Reader)
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	secret := []byte("a secret master token")
+	shares, err := shamir.Split(secret, 2, 3, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := shamir.Split(secret, 2, 3, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := shares[1]
+	truncated.Value = truncated.Value[:4]
+
+	tests := []struct {
+		name     string
+		shares   []shamir.Share
+		expected string
+	}{
+		{"None", nil, "libcipher/shamir: no shares"},
+		{"TooFew", shares[:1], "libcipher/shamir: 2 shares are required, got 1"},
+		{"Duplicate", []shamir.Share{shares[0], shares[0]}, "libcipher/shamir: share 1 was given twice"},
+		{"OtherSplit", []shamir.Share{shares[0], other[1]}, "libcipher/shamir: shares belong to different splits"},
+		{"DifferentLength", []shamir.Share{shares[0], truncated}, "libcipher/shamir: shares have different lengths"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := shamir.Combine(tt.shares)
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	secret := []byte("a secret master token")
+	shares, err := shamir.Split(secret, 2, 3, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := make([]shamir.Share, len(shares))
+	for i, share := range shares {
+		encoded := shamir.Format(share)
+		if !strings.HasPrefix(encoded, "u8share:") {
+			t.Fatalf("unexpected encoding %s", encoded)
+		}
+		parsed[i], err = shamir.Parse(" " + encoded + "\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	combined, err := shamir.Combine(parsed[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(combined, secret) {
+		t.Fatalf("recombined %q", combined)
+	}
+
+	// Every changed character is caught by the checksum or the encoding.
+	encoded := shamir.Format(shares[0])
+	for i := len("u8share:"); i < len(encoded); i++ {
+		replacement := byte('A')
+		if encoded[i] == 'A' {
+			replacement = 'B'
+		}
+		tampered := encoded[:i] + string(replacement) + encoded[i+1:]
+		if _, err := shamir.Parse(tampered); err == nil {
+			t.Fatalf("accepted share with character %d changed", i)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoded  string
+		expected string
+	}{
+		{"Prefix", "share:AAAA", "libcipher/shamir: share must start with u8share:"},
+		{"Short", "u8share:AQAAAAACAQ", "libcipher/shamir: share is too short"},
+		{"Checksum", "u8share:AQAAAAACAUIAAAAA", "libcipher/shamir: share checksum mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := shamir.Parse(tt.encoded)
+			if err == nil || err.Error() != tt.expected {
+				t.Fatalf("expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package libcipher
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+// signatureContext separates the signatures over cipher packages from any other use of the signing key.
+const signatureContext = "libcipher/signature"
+
+// signer signs every package of the wrapped Encryptor.
+type signer struct {
+	encryptor  Encryptor
+	signerID   uint32
+	privateKey ed25519.PrivateKey
+}
+
+// verifier checks the signature of a package before handing the inner package to the wrapped Decryptor.
+type verifier struct {
+	decryptor  Decryptor
+	publicKeys map[uint32]ed25519.PublicKey
+}
+
+// NewSigner creates an Encryptor signing every package of encryptor with an Ed25519 private key.
+//
+//	The final encrypted string format:
+//	[ Header | Inner Package | Signature (64 bytes) ]
+//
+// The header carries signerID as key id, the signature covers the header and the inner package.
+// Unlike the MAC of AES-CBC-HMAC, which every reader can forge, only the holder of the private key can sign.
+func NewSigner(encryptor Encryptor, signerID uint32, privateKey ed25519.PrivateKey) (Encryptor, error) {
+	if encryptor == nil {
+		return nil, InvalidUsageError("encryptor was nil")
+	}
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, IntegrityKeyError("signing key must be an Ed25519 private key")
+	}
+
+	return signer{encryptor: encryptor, signerID: signerID, privateKey: privateKey}, nil
+}
+
+// NewVerifier creates a Decryptor accepting only packages signed by one of publicKeys, given by signer id.
+// The inner package is decrypted by decryptor after the signature was verified.
+func NewVerifier(decryptor Decryptor, publicKeys map[uint32]ed25519.PublicKey) (Decryptor, error) {
+	if decryptor == nil {
+		return nil, InvalidUsageError("decryptor was nil")
+	}
+	if len(publicKeys) == 0 {
+		return nil, InvalidUsageError("no verification key given")
+	}
+	copied := make(map[uint32]ed25519.PublicKey, len(publicKeys))
+	for id, publicKey := range publicKeys {
+		if len(publicKey) != ed25519.PublicKeySize {
+			return nil, IntegrityKeyError(fmt.Sprintf("verification key %d must be an Ed25519 public key", id))
+		}
+		copied[id] = publicKey
+	}
+
+	return verifier{decryptor: decryptor, publicKeys: copied}, nil
+}
+
+// Crypt encrypts the message with the wrapped Encryptor and signs the package.
+func (s signer) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	inner, err := s.encryptor.Crypt(message, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	header := Header{Version: HeaderVersion2, Algorithm: AlgorithmEd25519, KeyID: s.signerID}
+	cipherpackage := header.append(make([]byte, 0, headerFixedLength+len(inner)+ed25519.SignatureSize))
+	cipherpackage = append(cipherpackage, inner...)
+	signature := ed25519.Sign(s.privateKey, signedMessage(cipherpackage))
+
+	return append(cipherpackage, signature...), nil
+}
+
+// Crypt verifies the signature and decrypts the inner package with the wrapped Decryptor.
+func (v verifier) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	inner, err := v.verify(cipherpackage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return v.decryptor.Crypt(inner)
+}
+
+// verify checks the signature of a package and returns the inner package.
+func (v verifier) verify(cipherpackage []byte) ([]byte, error) {
+	header, headerLength, err := ParseHeader(cipherpackage)
+	if err != nil {
+		return nil, err
+	}
+	if err := header.check(AlgorithmEd25519, 0); err != nil {
+		return nil, err
+	}
+	if len(cipherpackage) < headerLength+ed25519.SignatureSize {
+		return nil, CipherTextError("cipherText is too short for the signature")
+	}
+	publicKey, ok := v.publicKeys[header.KeyID]
+	if !ok {
+		return nil, CipherTextError(fmt.Sprintf("unknown signer %d", header.KeyID))
+	}
+	signatureLocation := len(cipherpackage) - ed25519.SignatureSize
+	if !ed25519.Verify(publicKey, signedMessage(cipherpackage[:signatureLocation]), cipherpackage[signatureLocation:]) {
+		return nil, fmt.Errorf("data integrity compromised %w", CipherTextError("signature verification failed"))
+	}
+
+	return cipherpackage[headerLength:signatureLocation], nil
+}
+
+// signedMessage prefixes the signed part of a package with the signature context.
+func signedMessage(cipherpackage []byte) []byte {
+	return append([]byte(signatureContext), cipherpackage...)
+}
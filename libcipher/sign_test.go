@@ -0,0 +1,87 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestSign_SignVerify(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	pipelinePublic, pipelinePrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := libcipher.NewCBCHMACEncryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewCBCHMACDecryptor(encryptionKey, integrityKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := libcipher.NewSigner(encryptor, 1, pipelinePrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := libcipher.NewVerifier(decryptor, map[uint32]ed25519.PublicKey{1: pipelinePublic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipherpackage, err := signer.Crypt([]byte("message"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, ad, err := verifier.Crypt(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, []byte("message")) || !bytes.Equal(ad, []byte("ad")) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+	}
+
+	// Another key holder can seal a valid inner package, but can't sign as the pipeline.
+	forger, err := libcipher.NewSigner(encryptor, 1, otherPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := forger.Crypt([]byte("forged"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := encryptor.Crypt([]byte("unsigned"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSigner := bytes.Clone(cipherpackage)
+	otherSigner[8] ^= 1
+	tampered := bytes.Clone(cipherpackage)
+	tampered[len(tampered)-70] ^= 1
+
+	var testCases = []struct {
+		name          string
+		cipherpackage []byte
+	}{
+		{name: "ForgedSignature", cipherpackage: forged},
+		{name: "Unsigned", cipherpackage: unsigned},
+		{name: "UnknownSigner", cipherpackage: otherSigner},
+		{name: "TamperedPackage", cipherpackage: tampered},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := verifier.Crypt(tc.cipherpackage); err == nil {
+				t.Fatal("expected an error verifying the package")
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package libcipher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"io"
+)
+
+// NewSIV creates an AES-SIV AEAD (RFC 5297) with the given key of 32, 48 or 64 bytes.
+// The first half of the key authenticates (S2V with AES-CMAC), the second half encrypts (AES-CTR).
+//
+// The synthetic IV is calculated as S2V(additional data, nonce, plaintext) and prepended to the ciphertext.
+// With a nonceSize of 0 the AEAD is deterministic: the same key, additional data and plaintext always yield the same ciphertext.
+//
+// Nonce-misuse resistance:
+//
+//	Repeating a nonce only reveals whether two messages with the same additional data are identical.
+//	Authenticity and confidentiality of different messages are not affected, unlike with GCM.
+func NewSIV(key []byte, nonceSize int) (cipher.AEAD, error) {
+	if len(key) != 32 && len(key) != 48 && len(key) != 64 {
+		return nil, EncryptionKeyError("AES-SIV key must be 32, 48 or 64 bytes")
+	}
+	if nonceSize < 0 {
+		return nil, InvalidUsageError("nonce size must not be negative")
+	}
+	macBlock, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctrBlock, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &siv{mac: newCMAC(macBlock), ctr: ctrBlock, nonceSize: nonceSize}, nil
+}
+
+// siv implements cipher.AEAD using AES-SIV.
+type siv struct {
+	mac       cmac
+	ctr       cipher.Block
+	nonceSize int
+}
+
+func (s *siv) NonceSize() int {
+	return s.nonceSize
+}
+
+func (s *siv) Overhead() int {
+	return aes.BlockSize
+}
+
+// Seal encrypts and authenticates plaintext, the result is ( Synthetic IV | Ciphertext ).
+func (s *siv) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != s.nonceSize {
+		panic("libcipher/cipher: incorrect nonce length given to AES-SIV")
+	}
+	v := s.s2v(additionalData, nonce, plaintext)
+	ret, out := sliceForAppend(dst, len(v)+len(plaintext))
+	copy(out, v[:])
+	s.xorKeyStream(v, out[len(v):], plaintext)
+
+	return ret
+}
+
+// Open authenticates and decrypts ciphertext.
+func (s *siv) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != s.nonceSize {
+		panic("libcipher/cipher: incorrect nonce length given to AES-SIV")
+	}
+	if len(ciphertext) < aes.BlockSize {
+		return nil, errors.New("libcipher/cipher: message authentication failed")
+	}
+	var v [aes.BlockSize]byte
+	copy(v[:], ciphertext)
+	ciphertext = ciphertext[aes.BlockSize:]
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	s.xorKeyStream(v, out, ciphertext)
+	expected := s.s2v(additionalData, nonce, out)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		// Don't release unauthenticated plaintext.
+		clear(out)
+		return nil, errors.New("libcipher/cipher: message authentication failed")
+	}
+
+	return ret, nil
+}
+
+// xorKeyStream runs AES-CTR with the synthetic IV as initial counter.
+func (s *siv) xorKeyStream(v [aes.BlockSize]byte, dst, src []byte) {
+	// Clear the 31st and 63rd bit (from the right) to allow 32 and 64 bit counter implementations.
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// s2v implements the S2V construction over the components additional data, nonce (if any) and plaintext.
+func (s *siv) s2v(additionalData, nonce, plaintext []byte) [aes.BlockSize]byte {
+	var zero [aes.BlockSize]byte
+	d := s.mac.sum(zero[:])
+	d = dbl(d)
+	xorBlock(&d, s.mac.sum(additionalData))
+	if s.nonceSize > 0 {
+		d = dbl(d)
+		xorBlock(&d, s.mac.sum(nonce))
+	}
+
+	var t []byte
+	if len(plaintext) >= aes.BlockSize {
+		// xorend: xor D into the last block of the plaintext.
+		t = make([]byte, len(plaintext))
+		copy(t, plaintext)
+		subtle.XORBytes(t[len(t)-aes.BlockSize:], t[len(t)-aes.BlockSize:], d[:])
+	} else {
+		d = dbl(d)
+		var padded [aes.BlockSize]byte
+		copy(padded[:], plaintext)
+		padded[len(plaintext)] = 0x80
+		xorBlock(&padded, d)
+		t = padded[:]
+	}
+
+	return s.mac.sum(t)
+}
+
+// cmac implements AES-CMAC (RFC 4493).
+type cmac struct {
+	block  cipher.Block
+	k1, k2 [aes.BlockSize]byte
+}
+
+func newCMAC(block cipher.Block) cmac {
+	var l [aes.BlockSize]byte
+	block.Encrypt(l[:], l[:])
+	k1 := dbl(l)
+
+	return cmac{block: block, k1: k1, k2: dbl(k1)}
+}
+
+// sum calculates the CMAC of message.
+func (c cmac) sum(message []byte) [aes.BlockSize]byte {
+	var x [aes.BlockSize]byte
+	for len(message) > aes.BlockSize {
+		subtle.XORBytes(x[:], x[:], message[:aes.BlockSize])
+		c.block.Encrypt(x[:], x[:])
+		message = message[aes.BlockSize:]
+	}
+	// The last block is either complete or padded with 10*.
+	var last [aes.BlockSize]byte
+	copy(last[:], message)
+	if len(message) == aes.BlockSize {
+		xorBlock(&last, c.k1)
+	} else {
+		last[len(message)] = 0x80
+		xorBlock(&last, c.k2)
+	}
+	xorBlock(&x, last)
+	c.block.Encrypt(x[:], x[:])
+
+	return x
+}
+
+// dbl multiplies a block by x in GF(2^128).
+func dbl(b [aes.BlockSize]byte) [aes.BlockSize]byte {
+	var out [aes.BlockSize]byte
+	carry := b[0] >> 7
+	for i := 0; i < aes.BlockSize-1; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[aes.BlockSize-1] = b[aes.BlockSize-1]<<1 ^ byte(subtle.ConstantTimeSelect(int(carry), 0x87, 0))
+
+	return out
+}
+
+func xorBlock(dst *[aes.BlockSize]byte, src [aes.BlockSize]byte) {
+	subtle.XORBytes(dst[:], dst[:], src[:])
+}
+
+// sliceForAppend extends in by n bytes, it returns the whole slice and the extension.
+func sliceForAppend(in []byte, n int) ([]byte, []byte) {
+	total := len(in) + n
+	var head []byte
+	if cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+
+	return head, head[len(in):]
+}
+
+// cryptorSIV implements the Encryptor and Decryptor interfaces using nonce-based AES-SIV.
+type cryptorSIV struct {
+	aead  cipher.AEAD
+	rand  io.Reader
+	keyID uint32
+}
+
+// Encryption mode.
+type encryptorSIV cryptorSIV
+
+// Decryption mode.
+type decryptorSIV cryptorSIV
+
+// NewSIVEncryptor creates a new Encryptor using nonce-based AES-SIV (RFC 5297) with the given key of 32, 48 or 64 bytes.
+//
+//	The final encrypted string format:
+//	[ Header | Nonce (16 bytes) | AD-Lenght | AD | Synthetic IV | Ciphertext ]
+//
+// Everything in front of the synthetic IV is authenticated.
+//
+// GCM Comparison:
+//
+//	Use AES-SIV over GCM when nonce uniqueness can't be guaranteed, e.g. for persisted or distributed data.
+//	An accidentally repeated nonce only leaks whether two messages are equal, it never breaks authenticity.
+//	AES-SIV takes two passes over the message and is slower than GCM.
+func NewSIVEncryptor(encyptionKey []byte, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	aead, err := NewSIV(encyptionKey, aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptorSIV{aead: aead, rand: rand}, nil
+}
+
+// NewSIVDecryptor creates a new Decryptor using nonce-based AES-SIV with the given key.
+func NewSIVDecryptor(encyptionKey []byte) (Decryptor, error) {
+	aead, err := NewSIV(encyptionKey, aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptorSIV{aead: aead}, nil
+}
+
+// Crypt encrypts the given message using AES-SIV with the provided additional data.
+func (e encryptorSIV) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError("message was nil")
+	}
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+
+	header := Header{Version: HeaderVersion2, Algorithm: AlgorithmAESSIV, KeyID: e.keyID}
+
+	return sealAEAD(nil, e.aead, header, NewRandomNonceSource(e.rand), message, additionalData)
+}
+
+// withKeyID returns a copy of the encryptor stamping id into the header of its packages.
+func (e encryptorSIV) withKeyID(id uint32) Encryptor {
+	e.keyID = id
+	return e
+}
+
+// Crypt decrypts the given cipher package using AES-SIV.
+func (d decryptorSIV) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+
+	return openAEAD(nil, d.aead, AlgorithmAESSIV, cipherpackage, false)
+}
@@ -0,0 +1,99 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestSIV_RFC5297(t *testing.T) {
+	// RFC 5297 A.1. Deterministic Authenticated Encryption Example
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	additionalData, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plaintext, _ := hex.DecodeString("112233445566778899aabbccddee")
+	expected, _ := hex.DecodeString("85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c")
+
+	aead, err := libcipher.NewSIV(key, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := aead.Seal(nil, nil, plaintext, additionalData)
+	if !bytes.Equal(ciphertext, expected) {
+		t.Fatalf("expected %x got %x", expected, ciphertext)
+	}
+	decrypted, err := aead.Open(nil, nil, ciphertext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %x : %x", decrypted, plaintext)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := aead.Open(nil, nil, ciphertext, additionalData); err == nil {
+		t.Fatal("expected an error opening a tampered ciphertext")
+	}
+}
+
+func TestSIV_EncryptDecrypt(t *testing.T) {
+	key := make([]byte, 64)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := libcipher.NewSIVEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewSIVDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plaintext := range [][]byte{{}, []byte("short"), bytes.Repeat([]byte("sixteen bytes..."), 5)} {
+		cipherpackage, err := encryptor.Crypt(plaintext, []byte("ad"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, ad, err := decryptor.Crypt(cipherpackage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) || !bytes.Equal(ad, []byte("ad")) {
+			t.Fatalf("Decrypted data doesn't match original plaintext: %s : %s", decrypted, plaintext)
+		}
+		cipherpackage[len(cipherpackage)-1] ^= 1
+		if _, _, err := decryptor.Crypt(cipherpackage); err == nil {
+			t.Fatal("expected an error decrypting a tampered package")
+		}
+	}
+	if _, err := libcipher.NewSIVEncryptor(key[:16], rand.Reader); err == nil {
+		t.Fatal("expected an error for a 16 byte key")
+	}
+}
+
+func TestSIV_NonceReuse(t *testing.T) {
+	key := make([]byte, 32)
+	aead, err := libcipher.NewSIV(key, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, 16)
+	first := aead.Seal(nil, nonce, []byte("first message"), nil)
+	again := aead.Seal(nil, nonce, []byte("first message"), nil)
+	second := aead.Seal(nil, nonce, []byte("other message"), nil)
+	if !bytes.Equal(first, again) {
+		t.Fatal("expected a repeated nonce to reveal equal messages")
+	}
+	// Unlike with GCM the keystream differs for different messages under the same nonce,
+	// the XOR of the ciphertexts does not reveal the XOR of the plaintexts.
+	leaked := true
+	for i, c := range []byte("first message") {
+		if first[16+i]^second[16+i] != c^"other message"[i] {
+			leaked = false
+		}
+	}
+	if leaked {
+		t.Fatal("expected different keystreams for different messages")
+	}
+}
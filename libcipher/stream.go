@@ -0,0 +1,69 @@
+package libcipher
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamSegmentSize is the amount of plaintext sealed into one stream segment.
+// Only the final segment of a stream may carry less.
+const streamSegmentSize = 64 * 1024
+
+// streamNonceSize is the size of the random nonce identifying a single stream.
+const streamNonceSize = 16
+
+// Domain separation tags, they keep a header MAC from ever being valid for a segment and vice versa.
+const (
+	streamHeaderTag  byte = 'H'
+	streamSegmentTag byte = 'S'
+)
+
+// Final segment flags.
+const (
+	streamSegmentFollows byte = 0
+	streamSegmentLast    byte = 1
+)
+
+// readHeader reads and parses the header in front of a stream.
+// It returns the header together with its encoding.
+func readHeader(r io.Reader) (Header, []byte, error) {
+	encoded := make([]byte, headerFixedLength)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: %w", CipherTextError("reading stream header"), err)
+	}
+	parametersLength := int(binary.BigEndian.Uint16(encoded[headerFixedLength-2:]))
+	encoded = append(encoded, make([]byte, parametersLength)...)
+	if _, err := io.ReadFull(r, encoded[headerFixedLength:]); err != nil {
+		return Header{}, nil, fmt.Errorf("%w: %w", CipherTextError("reading stream header"), err)
+	}
+	header, _, err := ParseHeader(encoded)
+	if err != nil {
+		return Header{}, nil, err
+	}
+
+	return header, encoded, nil
+}
+
+// readSegment reads the next sealed segment into buf.
+// It reports how many bytes were read and whether the segment is the last one of the stream.
+// A segment is the last one if the stream ends within or directly after it.
+func readSegment(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, true, nil
+	}
+	if err != nil {
+		return n, false, err
+	}
+	// The segment is full, peek to find out if anything follows.
+	if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+
+	return n, false, nil
+}
@@ -0,0 +1,284 @@
+package libcipher
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Configure & init the AES-CBC+HMAC cryptor in streaming encryption mode.
+// The returned writer seals everything written to it into w, Close must be called to seal the final segment.
+// Close does not close w.
+//
+//	The stream format:
+//	[ Header | MAC | AD-Lenght | AD | Stream Nonce ] [ Segment 0 ] [ Segment 1 ] ... [ Final Segment ]
+//	Every segment has the format:
+//	[ MAC | Initialization Vector | Block 1 | Block 2 | ... ]
+//	rand.Reader is used for introducing randomness, NewCBCHMACStreamWriterWithRand accepts another source.
+//
+// The plaintext is split into segments of 64 KiB, only one segment has to be in memory at any time.
+//
+// the header MAC is calculated from ( 'H' | Header | AD-Lenght | AD | Stream Nonce )
+// the segment MAC is calculated from ( 'S' | Stream Nonce | Segment Index | Final Flag | Initialization Vector | Block 1 | ... )
+//
+// Binding the stream nonce, the index and the final flag into every segment MAC
+// lets the reader detect reordered, dropped, duplicated or truncated segments.
+//
+// The same key considerations as for NewCBCHMACEncryptor apply.
+func NewCBCHMACStreamWriter(w io.Writer, encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash, additionalData []byte) (io.WriteCloser, error) {
+	return NewCBCHMACStreamWriterWithRand(w, encyptionKey, integrityKey, calculateMAC, rand.Reader, additionalData)
+}
+
+// NewCBCHMACStreamWriterWithRand is NewCBCHMACStreamWriter reading the stream nonce & initialization vectors from rand.
+// Use a deterministic source for reproducible streams in tests only, IVs have to be unpredictable.
+func NewCBCHMACStreamWriterWithRand(w io.Writer, encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash, rand io.Reader, additionalData []byte) (io.WriteCloser, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	if len(additionalData) > maxAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+	cry, err := newCBCHMACryptor(encyptionKey, integrityKey, calculateMAC)
+	if err != nil {
+		return nil, err
+	}
+	cry.rand = rand
+	nonce := make([]byte, streamNonceSize)
+	if _, err := io.ReadFull(rand, nonce); err != nil {
+		return nil, err
+	}
+
+	// Assemble & write the header.
+	header := Header{Version: HeaderVersion1, Algorithm: AlgorithmCBCHMACStream, Hash: cry.hash, KeyID: cry.keyID}.append(nil)
+	macLocation := len(header)
+	adHeaderLocation := macLocation + cry.macLenght
+	adLocation := adHeaderLocation + additionalDataHeaderLength
+	nonceLocation := adLocation + len(additionalData)
+	header = append(header, make([]byte, nonceLocation+streamNonceSize-macLocation)...)
+	binary.BigEndian.PutUint16(header[adHeaderLocation:adLocation], uint16(len(additionalData)))
+	copy(header[adLocation:nonceLocation], additionalData)
+	copy(header[nonceLocation:], nonce)
+	mac := generateSignatureParts(cry.integrityKey, cry.calcMac, []byte{streamHeaderTag}, header[:macLocation], header[adHeaderLocation:])
+	copy(header[macLocation:adHeaderLocation], mac)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &cbcHMACStreamWriter{
+		w:       w,
+		cryptor: cry,
+		nonce:   nonce,
+		buf:     make([]byte, 0, streamSegmentSize+cry.pher.BlockSize()),
+		sealed:  make([]byte, cry.macLenght+streamSegmentSize+2*cry.pher.BlockSize()),
+	}, nil
+}
+
+// Configure & init the AES-CBC+HMAC cryptor in streaming decryption mode.
+// The header of the stream is read and verified immediately, its additional data is returned.
+// Plaintext is released segment by segment and only after the segment was verified.
+func NewCBCHMACStreamReader(r io.Reader, encyptionKey []byte, integrityKey []byte, calculateMAC func() hash.Hash) (io.Reader, []byte, error) {
+	cry, err := newCBCHMACryptor(encyptionKey, integrityKey, calculateMAC)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Read & verify the header.
+	header, encoded, err := readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.Version != HeaderVersion1 {
+		return nil, nil, CipherTextError("unsupported stream version")
+	}
+	if err := header.check(AlgorithmCBCHMACStream, cry.hash); err != nil {
+		return nil, nil, err
+	}
+	fixed := make([]byte, cry.macLenght+additionalDataHeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", CipherTextError("reading stream header"), err)
+	}
+	adLength := int(binary.BigEndian.Uint16(fixed[cry.macLenght:]))
+	rest := make([]byte, adLength+streamNonceSize)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", CipherTextError("reading stream header"), err)
+	}
+	mac := generateSignatureParts(cry.integrityKey, cry.calcMac, []byte{streamHeaderTag}, encoded, fixed[cry.macLenght:], rest)
+	if !hmac.Equal(mac, fixed[:cry.macLenght]) {
+		return nil, nil, fmt.Errorf("data integrity compromised %w", errors.New("signature verification failed"))
+	}
+
+	return &cbcHMACStreamReader{
+		r:       bufio.NewReader(r),
+		cryptor: cry,
+		nonce:   rest[adLength:],
+		sealed:  make([]byte, cry.macLenght+streamSegmentSize+2*cry.pher.BlockSize()),
+	}, rest[:adLength], nil
+}
+
+// Streaming encryption mode of the cryptor.
+type cbcHMACStreamWriter struct {
+	w       io.Writer
+	cryptor cryptorCBCHMAC
+	nonce   []byte
+	index   uint64
+	buf     []byte
+	sealed  []byte
+	closed  bool
+	err     error
+}
+
+// Write buffers p and seals every completed segment.
+// A full segment is only sealed once more data arrives, as it could still be the final one.
+func (s *cbcHMACStreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, InvalidUsageError("write to closed stream")
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == streamSegmentSize {
+			if err := s.seal(streamSegmentFollows); err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):streamSegmentSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close seals the final segment.
+func (s *cbcHMACStreamWriter) Close() error {
+	if s.closed {
+		return InvalidUsageError("stream already closed")
+	}
+	if s.err != nil {
+		return s.err
+	}
+	s.closed = true
+
+	return s.seal(streamSegmentLast)
+}
+
+func (s *cbcHMACStreamWriter) seal(final byte) error {
+	blockSize := s.cryptor.pher.BlockSize()
+	// Apply PKCS#7 padding to the buffered segment.
+	pad := padPKCS7(len(s.buf), blockSize)
+	payload := append(s.buf, pad...)
+
+	ivLocation := s.cryptor.macLenght
+	cipherTextLocation := ivLocation + blockSize
+	segment := s.sealed[:cipherTextLocation+len(payload)]
+	// Generate a random initialization vector (IV).
+	iv := segment[ivLocation:cipherTextLocation]
+	if _, err := io.ReadFull(s.cryptor.rand, iv); err != nil {
+		s.err = err
+		return err
+	}
+	mode := cipher.NewCBCEncrypter(s.cryptor.pher, iv)
+	mode.CryptBlocks(segment[cipherTextLocation:], payload)
+	copy(segment[:ivLocation], signStreamSegment(s.cryptor, s.nonce, s.index, final, segment[ivLocation:]))
+
+	if _, err := s.w.Write(segment); err != nil {
+		s.err = err
+		return err
+	}
+	s.index++
+	s.buf = s.buf[:0]
+
+	return nil
+}
+
+// Streaming decryption mode of the cryptor.
+type cbcHMACStreamReader struct {
+	r       *bufio.Reader
+	cryptor cryptorCBCHMAC
+	nonce   []byte
+	index   uint64
+	sealed  []byte
+	plain   []byte
+	done    bool
+	err     error
+}
+
+// Read returns verified plaintext, io.EOF is only returned after the final segment was verified.
+func (s *cbcHMACStreamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.open()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+
+	return n, nil
+}
+
+func (s *cbcHMACStreamReader) open() error {
+	blockSize := s.cryptor.pher.BlockSize()
+	n, last, err := readSegment(s.r, s.sealed)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return CipherTextError("stream truncated")
+	}
+	ivLocation := s.cryptor.macLenght
+	cipherTextLocation := ivLocation + blockSize
+	if n < cipherTextLocation+blockSize || (n-cipherTextLocation)%blockSize != 0 {
+		return CipherTextError("stream segment is invalid")
+	}
+	segment := s.sealed[:n]
+	final := streamSegmentFollows
+	if last {
+		final = streamSegmentLast
+	}
+	mac := signStreamSegment(s.cryptor, s.nonce, s.index, final, segment[ivLocation:])
+	if !hmac.Equal(mac, segment[:ivLocation]) {
+		return fmt.Errorf("data integrity compromised %w", CipherTextError(fmt.Sprintf("segment %d verification failed", s.index)))
+	}
+
+	// Decrypt in place, the segment buffer is reused for the next segment only after all plaintext was consumed.
+	payload := segment[cipherTextLocation:]
+	mode := cipher.NewCBCDecrypter(s.cryptor.pher, segment[ivLocation:cipherTextLocation])
+	mode.CryptBlocks(payload, payload)
+	unpadIndex, err := unpadPKCS7(payload)
+	if err != nil {
+		return err
+	}
+	s.plain = payload[:unpadIndex]
+	s.index++
+	s.done = last
+
+	return nil
+}
+
+// signStreamSegment calculates the MAC of a single segment bound to its stream, position and final flag.
+func signStreamSegment(cryptor cryptorCBCHMAC, nonce []byte, index uint64, final byte, segment []byte) []byte {
+	var position [9]byte
+	binary.BigEndian.PutUint64(position[:8], index)
+	position[8] = final
+
+	h := hmac.New(cryptor.calcMac, cryptor.integrityKey)
+	h.Write([]byte{streamSegmentTag})
+	h.Write(nonce)
+	h.Write(position[:])
+	h.Write(segment)
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,291 @@
+package libcipher
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// streamNoncePrefixSize is the size of the random per stream part of every GCM segment nonce.
+	// The remaining 5 bytes of the 12 byte nonce hold the segment counter and the final flag.
+	streamNoncePrefixSize = 7
+	// streamSaltSize is the size of the random salt the key of a stream is derived with.
+	streamSaltSize = 32
+	// streamSubkeyContext binds the derived stream keys to their use.
+	streamSubkeyContext = "libcipher/gcm-stream/subkey"
+)
+
+// NewGCMStreamWriter creates a streaming Encryptor using AES-GCM with the given key (STREAM construction).
+// The returned writer seals everything written to it into w, Close must be called to seal the final segment.
+// Close does not close w.
+//
+//	The stream format:
+//	[ Header (Salt as parameters) | AD-Lenght | AD | Nonce Prefix ] [ Segment 0 ] [ Segment 1 ] ... [ Final Segment ]
+//	Every segment has the format:
+//	[ Ciphertext | Authentication Tag ]
+//
+// Every stream is sealed with its own key, derived by HKDF-SHA256 from the given key and a random 32 byte salt.
+// Nonces can't repeat across streams this way, the 7 byte random prefix alone would after about 2^28 streams.
+// The nonce of every segment is derived as ( Nonce Prefix | Segment Counter (4 bytes) | Final Flag ),
+// so no nonce is ever used twice within a stream. The salt & the prefix are drawn from rand.
+// Everything in front of segment 0 is its additional data, this binds the header to the stream.
+//
+// The plaintext is split into segments of 64 KiB, a stream holds at most 2^32 segments (256 TiB).
+func NewGCMStreamWriter(w io.Writer, encyptionKey []byte, rand io.Reader, additionalData []byte) (io.WriteCloser, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	if len(additionalData) > maxAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+	if _, err := newGCMCryptor(encyptionKey); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, err
+	}
+	cryptor, err := newGCMStreamCryptor(encyptionKey, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Assemble & write the header.
+	header := Header{Version: HeaderVersion1, Algorithm: AlgorithmGCMStream, KeyID: cryptor.keyID, Parameters: salt}.append(nil)
+	adHeaderLocation := len(header)
+	adLocation := adHeaderLocation + additionalDataHeaderLength
+	prefixLocation := adLocation + len(additionalData)
+	header = append(header, make([]byte, prefixLocation+streamNoncePrefixSize-adHeaderLocation)...)
+	binary.BigEndian.PutUint16(header[adHeaderLocation:adLocation], uint16(len(additionalData)))
+	copy(header[adLocation:prefixLocation], additionalData)
+	if _, err := io.ReadFull(rand, header[prefixLocation:]); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &gcmStreamWriter{
+		w:      w,
+		gcm:    cryptor.gcm,
+		header: header,
+		prefix: header[prefixLocation:],
+		buf:    make([]byte, 0, streamSegmentSize),
+		sealed: make([]byte, 0, streamSegmentSize+cryptor.gcm.Overhead()),
+	}, nil
+}
+
+// NewGCMStreamReader creates a streaming Decryptor using AES-GCM with the given key.
+// The header of the stream is read immediately, its additional data is returned.
+// The additional data is authenticated together with the first segment, so it must not be trusted before the first Read succeeded.
+// Plaintext is released segment by segment and only after the segment was authenticated.
+func NewGCMStreamReader(r io.Reader, encyptionKey []byte) (io.Reader, []byte, error) {
+	if _, err := newGCMCryptor(encyptionKey); err != nil {
+		return nil, nil, err
+	}
+
+	// Read the header.
+	parsed, header, err := readHeader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parsed.Version != HeaderVersion1 {
+		return nil, nil, CipherTextError("unsupported stream version")
+	}
+	if err := parsed.check(AlgorithmGCMStream, 0); err != nil {
+		return nil, nil, err
+	}
+	if len(parsed.Parameters) != streamSaltSize {
+		return nil, nil, CipherTextError("invalid stream salt")
+	}
+	cryptor, err := newGCMStreamCryptor(encyptionKey, parsed.Parameters)
+	if err != nil {
+		return nil, nil, err
+	}
+	adHeaderLocation := len(header)
+	adLocation := adHeaderLocation + additionalDataHeaderLength
+	header = append(header, make([]byte, additionalDataHeaderLength)...)
+	if _, err := io.ReadFull(r, header[adHeaderLocation:]); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", CipherTextError("reading stream header"), err)
+	}
+	adLength := int(binary.BigEndian.Uint16(header[adHeaderLocation:]))
+	header = append(header, make([]byte, adLength+streamNoncePrefixSize)...)
+	if _, err := io.ReadFull(r, header[adLocation:]); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", CipherTextError("reading stream header"), err)
+	}
+	prefixLocation := adLocation + adLength
+
+	return &gcmStreamReader{
+		r:      bufio.NewReader(r),
+		gcm:    cryptor.gcm,
+		header: header,
+		prefix: header[prefixLocation:],
+		sealed: make([]byte, streamSegmentSize+cryptor.gcm.Overhead()),
+	}, header[adLocation:prefixLocation], nil
+}
+
+// Streaming encryption mode.
+type gcmStreamWriter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint64
+	buf    []byte
+	sealed []byte
+	closed bool
+	err    error
+}
+
+// Write buffers p and seals every completed segment.
+// A full segment is only sealed once more data arrives, as it could still be the final one.
+func (s *gcmStreamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, InvalidUsageError("write to closed stream")
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == streamSegmentSize {
+			if err := s.seal(streamSegmentFollows); err != nil {
+				return n, err
+			}
+		}
+		c := copy(s.buf[len(s.buf):streamSegmentSize], p)
+		s.buf = s.buf[:len(s.buf)+c]
+		p = p[c:]
+		n += c
+	}
+
+	return n, nil
+}
+
+// Close seals the final segment.
+func (s *gcmStreamWriter) Close() error {
+	if s.closed {
+		return InvalidUsageError("stream already closed")
+	}
+	if s.err != nil {
+		return s.err
+	}
+	s.closed = true
+
+	return s.seal(streamSegmentLast)
+}
+
+func (s *gcmStreamWriter) seal(final byte) error {
+	nonce, err := streamSegmentNonce(s.prefix, s.index, final)
+	if err != nil {
+		s.err = err
+		return err
+	}
+	segment := s.gcm.Seal(s.sealed[:0], nonce, s.buf, streamSegmentAD(s.header, s.index))
+	if _, err := s.w.Write(segment); err != nil {
+		s.err = err
+		return err
+	}
+	s.index++
+	s.buf = s.buf[:0]
+
+	return nil
+}
+
+// Streaming decryption mode.
+type gcmStreamReader struct {
+	r      *bufio.Reader
+	gcm    cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint64
+	sealed []byte
+	plain  []byte
+	done   bool
+	err    error
+}
+
+// Read returns authenticated plaintext, io.EOF is only returned after the final segment was authenticated.
+func (s *gcmStreamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.open()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+
+	return n, nil
+}
+
+func (s *gcmStreamReader) open() error {
+	n, last, err := readSegment(s.r, s.sealed)
+	if err != nil {
+		return err
+	}
+	if n < s.gcm.Overhead() {
+		return CipherTextError("stream truncated")
+	}
+	final := streamSegmentFollows
+	if last {
+		final = streamSegmentLast
+	}
+	nonce, err := streamSegmentNonce(s.prefix, s.index, final)
+	if err != nil {
+		return err
+	}
+	// Decrypt in place, the segment buffer is reused for the next segment only after all plaintext was consumed.
+	plain, err := s.gcm.Open(s.sealed[:0], nonce, s.sealed[:n], streamSegmentAD(s.header, s.index))
+	if err != nil {
+		return fmt.Errorf("data integrity compromised %w", CipherTextError(fmt.Sprintf("segment %d authentication failed", s.index)))
+	}
+	s.plain = plain
+	s.index++
+	s.done = last
+
+	return nil
+}
+
+// newGCMStreamCryptor creates the cryptor of a stream with the key derived from the given key & the salt of the stream.
+func newGCMStreamCryptor(encyptionKey []byte, salt []byte) (cryptorGCM, error) {
+	subkey := make([]byte, len(encyptionKey))
+	defer clear(subkey)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, encyptionKey, salt, []byte(streamSubkeyContext)), subkey); err != nil {
+		return cryptorGCM{}, err
+	}
+
+	return newGCMCryptor(subkey)
+}
+
+// streamSegmentNonce derives the nonce of a segment from the stream prefix, its index and the final flag.
+func streamSegmentNonce(prefix []byte, index uint64, final byte) ([]byte, error) {
+	if index > math.MaxUint32 {
+		return nil, MessageError("stream too large for GCM")
+	}
+	nonce := make([]byte, streamNoncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamNoncePrefixSize:], uint32(index))
+	nonce[len(nonce)-1] = final
+
+	return nonce, nil
+}
+
+// streamSegmentAD returns the additional data of a segment, only the first one is bound to the stream header.
+func streamSegmentAD(header []byte, index uint64) []byte {
+	if index == 0 {
+		return header
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+	"golang.org/x/crypto/hkdf"
+)
+
+const testSegmentSize = 64 * 1024
+
+// Size of the package header without parameters.
+const testHeaderSize = 13
+
+func TestCBCHMACStream_EncryptDecrypt(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	var testCases = []struct {
+		name           string
+		size           int
+		additionalData []byte
+	}{
+		{name: "Empty", size: 0},
+		{name: "Small", size: 42, additionalData: []byte("backup.tar")},
+		{name: "ExactlyOneSegment", size: testSegmentSize},
+		{name: "MultipleSegments", size: 3*testSegmentSize + 17},
+		{name: "ExactlyTwoSegments", size: 2 * testSegmentSize},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plaintext := make([]byte, tc.size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatal(err)
+			}
+			sealed := sealCBCStream(t, encryptionKey, integrityKey, plaintext, tc.additionalData)
+
+			reader, additionalData, err := libcipher.NewCBCHMACStreamReader(bytes.NewReader(sealed), encryptionKey, integrityKey, sha256.New)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(additionalData, tc.additionalData) {
+				t.Fatalf("additional data mismatch: %q : %q", additionalData, tc.additionalData)
+			}
+			decrypted, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Fatal("Decrypted data doesn't match original plaintext")
+			}
+		})
+	}
+}
+
+func TestCBCHMACStream_Tampering(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	integrityKey := []byte("anothersecretintegritykey12345671234")
+	plaintext := make([]byte, 3*testSegmentSize+17)
+	sealed := sealCBCStream(t, encryptionKey, integrityKey, plaintext, nil)
+	// Header + MAC + AD-Length + Stream Nonce
+	headerSize := testHeaderSize + sha256.Size + 2 + 16
+	segmentSize := sha256.Size + testSegmentSize + 2*16
+
+	first := sealed[headerSize : headerSize+segmentSize]
+	second := sealed[headerSize+segmentSize : headerSize+2*segmentSize]
+	reordered := append(append(append([]byte{}, sealed[:headerSize]...), second...), first...)
+	reordered = append(reordered, sealed[headerSize+2*segmentSize:]...)
+
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)-1] ^= 1
+
+	var testCases = []struct {
+		name   string
+		sealed []byte
+	}{
+		{name: "TruncatedAtSegmentBoundary", sealed: sealed[:headerSize+segmentSize]},
+		{name: "TruncatedWithinSegment", sealed: sealed[:len(sealed)-5]},
+		{name: "HeaderOnly", sealed: sealed[:headerSize]},
+		{name: "ReorderedSegments", sealed: reordered},
+		{name: "FlippedBit", sealed: flipped},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, _, err := libcipher.NewCBCHMACStreamReader(bytes.NewReader(tc.sealed), encryptionKey, integrityKey, sha256.New)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(reader); err == nil {
+				t.Fatal("expected an error reading a tampered stream")
+			}
+		})
+	}
+}
+
+func sealCBCStream(t *testing.T, encryptionKey []byte, integrityKey []byte, plaintext []byte, additionalData []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	writer, err := libcipher.NewCBCHMACStreamWriter(&sealed, encryptionKey, integrityKey, sha256.New, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in odd chunks to cross segment boundaries.
+	for chunk := plaintext; len(chunk) > 0; {
+		n := min(len(chunk), 1000)
+		if _, err := writer.Write(chunk[:n]); err != nil {
+			t.Fatal(err)
+		}
+		chunk = chunk[n:]
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return sealed.Bytes()
+}
+
+func TestGCMStream_EncryptDecrypt(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	var testCases = []struct {
+		name           string
+		size           int
+		additionalData []byte
+	}{
+		{name: "Empty", size: 0},
+		{name: "Small", size: 42, additionalData: []byte("artifact.bin")},
+		{name: "ExactlyOneSegment", size: testSegmentSize},
+		{name: "MultipleSegments", size: 3*testSegmentSize + 17},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plaintext := make([]byte, tc.size)
+			if _, err := rand.Read(plaintext); err != nil {
+				t.Fatal(err)
+			}
+			sealed := sealGCMStream(t, encryptionKey, plaintext, tc.additionalData)
+
+			reader, additionalData, err := libcipher.NewGCMStreamReader(bytes.NewReader(sealed), encryptionKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(additionalData, tc.additionalData) {
+				t.Fatalf("additional data mismatch: %q : %q", additionalData, tc.additionalData)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Fatal("Decrypted data doesn't match original plaintext")
+			}
+		})
+	}
+}
+
+func TestGCMStream_Tampering(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	plaintext := make([]byte, 3*testSegmentSize+17)
+	sealed := sealGCMStream(t, encryptionKey, plaintext, []byte("ad"))
+	// Header + Salt + AD-Length + AD + Nonce Prefix
+	headerSize := testHeaderSize + 32 + 2 + 2 + 7
+	segmentSize := testSegmentSize + 16
+
+	first := sealed[headerSize : headerSize+segmentSize]
+	second := sealed[headerSize+segmentSize : headerSize+2*segmentSize]
+	reordered := append(append(append([]byte{}, sealed[:headerSize]...), second...), first...)
+	reordered = append(reordered, sealed[headerSize+2*segmentSize:]...)
+
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)-1] ^= 1
+
+	otherHeader := append([]byte{}, sealed...)
+	otherHeader[testHeaderSize+32+2] ^= 1
+
+	otherSalt := append([]byte{}, sealed...)
+	otherSalt[testHeaderSize] ^= 1
+
+	var testCases = []struct {
+		name   string
+		sealed []byte
+	}{
+		{name: "TruncatedAtSegmentBoundary", sealed: sealed[:headerSize+segmentSize]},
+		{name: "TruncatedWithinSegment", sealed: sealed[:len(sealed)-5]},
+		{name: "HeaderOnly", sealed: sealed[:headerSize]},
+		{name: "ReorderedSegments", sealed: reordered},
+		{name: "FlippedBit", sealed: flipped},
+		{name: "ModifiedAdditionalData", sealed: otherHeader},
+		{name: "ModifiedSalt", sealed: otherSalt},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reader, _, err := libcipher.NewGCMStreamReader(bytes.NewReader(tc.sealed), encryptionKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(reader); err == nil {
+				t.Fatal("expected an error reading a tampered stream")
+			}
+		})
+	}
+}
+
+func TestGCMStream_PerStreamKey(t *testing.T) {
+	encryptionKey := []byte("mysecretencryptionkey12345671234")
+	plaintext := []byte("the same message in both streams")
+	prefix := bytes.Repeat([]byte{7}, 7)
+	salts := [][]byte{bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)}
+
+	// Both streams draw the same nonce prefix, only the salt differs.
+	var segments [][]byte
+	for _, salt := range salts {
+		var sealed bytes.Buffer
+		writer, err := libcipher.NewGCMStreamWriter(&sealed, encryptionKey, bytes.NewReader(append(bytes.Clone(salt), prefix...)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(plaintext); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		headerSize := testHeaderSize + 32 + 2 + 7
+		header, segment := sealed.Bytes()[:headerSize], sealed.Bytes()[headerSize:]
+		if !bytes.Equal(header[testHeaderSize:testHeaderSize+32], salt) {
+			t.Fatal("salt not stored in the stream header")
+		}
+
+		// The segment opens with the key derived from the salt, not with the key itself.
+		subkey := make([]byte, len(encryptionKey))
+		if _, err := io.ReadFull(hkdf.New(sha256.New, encryptionKey, salt, []byte("libcipher/gcm-stream/subkey")), subkey); err != nil {
+			t.Fatal(err)
+		}
+		nonce := append(bytes.Clone(prefix), 0, 0, 0, 0, 1)
+		for _, key := range [][]byte{subkey, encryptionKey} {
+			block, err := aes.NewCipher(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gcm, err := cipher.NewGCM(block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opened, err := gcm.Open(nil, nonce, segment, header)
+			if ok := err == nil && bytes.Equal(opened, plaintext); ok != bytes.Equal(key, subkey) {
+				t.Fatalf("opening with the derived key %t: %v", bytes.Equal(key, subkey), err)
+			}
+		}
+		segments = append(segments, segment)
+	}
+	if bytes.Equal(segments[0], segments[1]) {
+		t.Fatal("streams with the same nonce prefix use the same key")
+	}
+}
+
+func sealGCMStream(t *testing.T, encryptionKey []byte, plaintext []byte, additionalData []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	writer, err := libcipher.NewGCMStreamWriter(&sealed, encryptionKey, rand.Reader, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(writer, bytes.NewReader(plaintext)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return sealed.Bytes()
+}
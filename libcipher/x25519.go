@@ -0,0 +1,224 @@
+package libcipher
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Prefixes of the string encodings of X25519 keys.
+const (
+	recipientPrefix = "x25519:"
+	identityPrefix  = "x25519-identity:"
+)
+
+// x25519Context binds the keys derived from a shared secret to this construction.
+const x25519Context = "libcipher/x25519"
+
+// GenerateX25519Identity generates an X25519 key pair from rand.
+// The private key is the identity used to decrypt, its public key is the recipient used to encrypt.
+func GenerateX25519Identity(rand io.Reader) (*ecdh.PrivateKey, error) {
+	if rand == nil {
+		return nil, KeyGenerationError("rand was nil")
+	}
+	seed := make([]byte, 32)
+	if _, err := io.ReadFull(rand, seed); err != nil {
+		return nil, fmt.Errorf("%w:%w", KeyGenerationError("error generating key"), err)
+	}
+	defer clear(seed)
+
+	return ecdh.X25519().NewPrivateKey(seed)
+}
+
+// FormatRecipient encodes an X25519 public key as "x25519:" followed by the unpadded base64url encoded key.
+func FormatRecipient(recipient *ecdh.PublicKey) string {
+	return recipientPrefix + base64.RawURLEncoding.EncodeToString(recipient.Bytes())
+}
+
+// ParseRecipient decodes a recipient encoded by FormatRecipient.
+func ParseRecipient(recipient string) (*ecdh.PublicKey, error) {
+	key, err := parseX25519Key(recipient, recipientPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return ecdh.X25519().NewPublicKey(key)
+}
+
+// FormatIdentity encodes an X25519 private key as "x25519-identity:" followed by the unpadded base64url encoded key.
+// The result is secret and must be stored like any other key.
+func FormatIdentity(identity *ecdh.PrivateKey) string {
+	return identityPrefix + base64.RawURLEncoding.EncodeToString(identity.Bytes())
+}
+
+// ParseIdentity decodes an identity encoded by FormatIdentity.
+func ParseIdentity(identity string) (*ecdh.PrivateKey, error) {
+	key, err := parseX25519Key(identity, identityPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+
+	return ecdh.X25519().NewPrivateKey(key)
+}
+
+func parseX25519Key(encoded string, prefix string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if !strings.HasPrefix(encoded, prefix) {
+		return nil, EncryptionKeyError("key must start with " + prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(encoded[len(prefix):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", EncryptionKeyError("key is not valid base64url"), err)
+	}
+	if len(key) != 32 {
+		return nil, EncryptionKeyError("key must be 32 bytes")
+	}
+
+	return key, nil
+}
+
+// encryptorX25519 seals every message to the public key of a recipient.
+type encryptorX25519 struct {
+	recipient *ecdh.PublicKey
+	rand      io.Reader
+	keyID     uint32
+}
+
+// decryptorX25519 opens packages sealed to the public key of its identity.
+type decryptorX25519 struct {
+	identity *ecdh.PrivateKey
+}
+
+// NewX25519Encryptor creates an Encryptor sealing messages to the holder of the private key of recipient.
+// It only needs the public key, the packages can't be decrypted by the encryptor.
+//
+//	The final encrypted string format:
+//	[ Header | AD-Lenght | AD | Ciphertext | Authentication Tag ]
+//
+// Every message is sealed under a fresh ephemeral X25519 key pair, its public key is stored in the header parameters.
+// The ChaCha20-Poly1305 key is derived by HKDF-SHA256 from the X25519 shared secret,
+// salted with the ephemeral and the recipient public key, as in HPKE (RFC 9180).
+// The key is used for a single message only, everything in front of the ciphertext is authenticated.
+//
+// There is no sender authentication, anyone knowing the recipient can create packages for it.
+func NewX25519Encryptor(recipient *ecdh.PublicKey, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	if recipient == nil || recipient.Curve() != ecdh.X25519() {
+		return nil, EncryptionKeyError("recipient must be an X25519 public key")
+	}
+
+	return encryptorX25519{recipient: recipient, rand: rand}, nil
+}
+
+// NewX25519Decryptor creates a Decryptor for packages sealed to the public key of identity.
+func NewX25519Decryptor(identity *ecdh.PrivateKey) (Decryptor, error) {
+	if identity == nil || identity.Curve() != ecdh.X25519() {
+		return nil, EncryptionKeyError("identity must be an X25519 private key")
+	}
+
+	return decryptorX25519{identity: identity}, nil
+}
+
+// Crypt seals the message to the recipient with the provided additional data.
+func (e encryptorX25519) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError("message was nil")
+	}
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+	ephemeral, err := GenerateX25519Identity(e.rand)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(e.recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	aead, err := newX25519AEAD(shared, ephemeralPublic, e.recipient.Bytes(), x25519Context)
+	if err != nil {
+		return nil, err
+	}
+	header := Header{Version: HeaderVersion2, Algorithm: AlgorithmX25519, KeyID: e.keyID, Parameters: ephemeralPublic}
+
+	return sealAEAD(nil, aead, header, nil, message, additionalData)
+}
+
+// withKeyID returns a copy of the encryptor stamping id into the header of its packages.
+func (e encryptorX25519) withKeyID(id uint32) Encryptor {
+	e.keyID = id
+	return e
+}
+
+// Crypt decrypts a package sealed to the public key of the identity.
+func (d decryptorX25519) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+	header, _, err := ParseHeader(cipherpackage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := header.check(AlgorithmX25519, 0); err != nil {
+		return nil, nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(header.Parameters)
+	if err != nil {
+		return nil, nil, CipherTextError("invalid ephemeral public key")
+	}
+	shared, err := d.identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, nil, CipherTextError("invalid ephemeral public key")
+	}
+	aead, err := newX25519AEAD(shared, header.Parameters, d.identity.PublicKey().Bytes(), x25519Context)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return openAEAD(nil, aead, AlgorithmX25519, cipherpackage, false)
+}
+
+// newX25519AEAD derives a single-use AEAD bound to context from the X25519 shared secret.
+func newX25519AEAD(shared []byte, ephemeralPublic []byte, recipientPublic []byte, context string) (cipher.AEAD, error) {
+	defer clear(shared)
+	salt := bytes.Join([][]byte{ephemeralPublic, recipientPublic}, nil)
+	key, err := DeriveKey(shared, salt, context, chacha20poly1305.KeySize, sha256.New)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return singleUseAEAD{aead}, nil
+}
+
+// singleUseAEAD adapts an AEAD whose key seals a single message only, its nonce is fixed to zero and not part of the package.
+type singleUseAEAD struct {
+	cipher.AEAD
+}
+
+func (a singleUseAEAD) NonceSize() int {
+	return 0
+}
+
+func (a singleUseAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	return a.AEAD.Seal(dst, make([]byte, a.AEAD.NonceSize()), plaintext, additionalData)
+}
+
+func (a singleUseAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	return a.AEAD.Open(dst, make([]byte, a.AEAD.NonceSize()), ciphertext, additionalData)
+}
@@ -0,0 +1,95 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestX25519_EncryptDecrypt(t *testing.T) {
+	identity, err := libcipher.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := libcipher.ParseRecipient(libcipher.FormatRecipient(identity.PublicKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptor, err := libcipher.NewX25519Encryptor(recipient, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decryptor, err := libcipher.NewX25519Decryptor(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, ad, err := decryptor.Crypt(cipherpackage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, []byte("message")) || !bytes.Equal(ad, []byte("ad")) {
+		t.Fatalf("Decrypted data doesn't match original plaintext: %s", decrypted)
+	}
+
+	other, err := libcipher.GenerateX25519Identity(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDecryptor, err := libcipher.NewX25519Decryptor(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := otherDecryptor.Crypt(cipherpackage); err == nil {
+		t.Fatal("expected an error decrypting with another identity")
+	}
+
+	// Replacing the ephemeral public key must fail.
+	tampered := bytes.Clone(cipherpackage)
+	tampered[testHeaderSize] ^= 1
+	if _, _, err := decryptor.Crypt(tampered); err == nil {
+		t.Fatal("expected an error decrypting a tampered package")
+	}
+}
+
+func TestX25519_Encoding(t *testing.T) {
+	identity, err := libcipher.GenerateX25519Identity(bytes.NewReader(bytes.Repeat([]byte{0x42}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := libcipher.FormatIdentity(identity)
+	if !strings.HasPrefix(encoded, "x25519-identity:") {
+		t.Fatalf("unexpected identity %s", encoded)
+	}
+	parsed, err := libcipher.ParseIdentity(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Equal(identity) {
+		t.Fatal("identity mismatch")
+	}
+
+	var testCases = []struct {
+		name      string
+		recipient string
+	}{
+		{name: "MissingPrefix", recipient: strings.TrimPrefix(libcipher.FormatRecipient(identity.PublicKey()), "x25519:")},
+		{name: "Identity", recipient: encoded},
+		{name: "Short", recipient: "x25519:AAAA"},
+		{name: "InvalidBase64", recipient: "x25519:!!!!"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := libcipher.ParseRecipient(tc.recipient); err == nil {
+				t.Fatal("expected an error parsing the recipient")
+			}
+		})
+	}
+}
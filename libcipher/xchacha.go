@@ -0,0 +1,102 @@
+package libcipher
+
+import (
+	"crypto/cipher"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// cryptorXChaCha implements the Encryptor and Decryptor interfaces using XChaCha20-Poly1305.
+type cryptorXChaCha struct {
+	aead  cipher.AEAD
+	rand  io.Reader
+	keyID uint32
+}
+
+// Encryption mode.
+type encryptorXChaCha cryptorXChaCha
+
+// Decryption mode.
+type decryptorXChaCha cryptorXChaCha
+
+// NewXChaChaEncryptor creates a new Encryptor using XChaCha20-Poly1305 with the given 32 byte key.
+//
+//	The final encrypted string format:
+//	[ Header | Nonce (24 bytes) | AD-Lenght | AD | Ciphertext | Authentication Tag ]
+//
+// Everything in front of the ciphertext is passed to the AEAD as additional data.
+//
+// AES-GCM Comparison:
+//
+//	The 192-bit nonce is large enough to be drawn from rand for every message without practical risk of a collision,
+//	this makes XChaCha20-Poly1305 safe for high-volume, distributed and persisted data where GCM is not.
+//	It is a single-key AEAD, no separate integrity key is needed.
+//	ChaCha20 is constant-time in software and fast on machines without AES-NI.
+func NewXChaChaEncryptor(encyptionKey []byte, rand io.Reader) (Encryptor, error) {
+	if rand == nil {
+		return nil, InvalidUsageError("rand was nil")
+	}
+	cryptor, err := newXChaChaCryptor(encyptionKey)
+	if err != nil {
+		return nil, err
+	}
+	cryptor.rand = rand
+
+	return (encryptorXChaCha)(cryptor), nil
+}
+
+// NewXChaChaDecryptor creates a new Decryptor using XChaCha20-Poly1305 with the given 32 byte key.
+func NewXChaChaDecryptor(encyptionKey []byte) (Decryptor, error) {
+	cryptor, err := newXChaChaCryptor(encyptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return (decryptorXChaCha)(cryptor), nil
+}
+
+func newXChaChaCryptor(encyptionKey []byte) (cryptorXChaCha, error) {
+	if len(encyptionKey) != chacha20poly1305.KeySize {
+		return cryptorXChaCha{}, EncryptionKeyError("encryption key must be 32 bytes")
+	}
+	aead, err := chacha20poly1305.NewX(encyptionKey)
+	if err != nil {
+		return cryptorXChaCha{}, err
+	}
+
+	return cryptorXChaCha{aead: aead}, nil
+}
+
+// Crypt encrypts the given message using XChaCha20-Poly1305 with the provided additional data.
+func (e encryptorXChaCha) Crypt(message []byte, additionalData []byte) ([]byte, error) {
+	if message == nil {
+		return nil, MessageError("message was nil")
+	}
+	// The 32-bit block counter of ChaCha20 limits a message to 256 GiB.
+	if uint64(len(message)) > (1<<38)-64 {
+		return nil, MessageError("message too large for XChaCha20-Poly1305")
+	}
+	if uint64(len(additionalData)) > maxPackageAdditionalDataSize {
+		return nil, MessageError("additional data too large")
+	}
+
+	header := Header{Version: HeaderVersion2, Algorithm: AlgorithmXChaCha20, KeyID: e.keyID}
+
+	return sealAEAD(nil, e.aead, header, NewRandomNonceSource(e.rand), message, additionalData)
+}
+
+// withKeyID returns a copy of the encryptor stamping id into the header of its packages.
+func (e encryptorXChaCha) withKeyID(id uint32) Encryptor {
+	e.keyID = id
+	return e
+}
+
+// Crypt decrypts the given cipher package using XChaCha20-Poly1305.
+func (d decryptorXChaCha) Crypt(cipherpackage []byte) ([]byte, []byte, error) {
+	if cipherpackage == nil {
+		return nil, nil, CipherTextError("cipherText was nil")
+	}
+
+	return openAEAD(nil, d.aead, AlgorithmXChaCha20, cipherpackage, false)
+}
@@ -0,0 +1,100 @@
+package libcipher_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+func TestXChaCha_EncryptDecrypt(t *testing.T) {
+	var testCases = []struct {
+		name           string
+		plaintext      []byte
+		additionalData []byte
+		encryptionKey  []byte
+		expectedError  error
+	}{
+		{
+			name:           "SuccessfulEncryptionDecryption",
+			plaintext:      []byte("This is some super secret data to encrypt."),
+			additionalData: []byte("additional data"),
+			encryptionKey:  []byte("mysecretencryptionkey12345671234"),
+		},
+		{
+			name:          "EmptyPlaintext",
+			plaintext:     []byte(""),
+			encryptionKey: []byte("mysecretencryptionkey12345671234"),
+		},
+		{
+			name:          "ShortEncryptionKey",
+			plaintext:     []byte("Some data"),
+			encryptionKey: []byte("too_short"),
+			expectedError: fmt.Errorf("libcipher/cipher: encryption key must be 32 bytes"),
+		},
+		{
+			name:          "NilPlaintext",
+			plaintext:     nil,
+			encryptionKey: []byte("mysecretencryptionkey12345671234"),
+			expectedError: fmt.Errorf("libcipher/cipher: message was nil"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			encryptor, err := libcipher.NewXChaChaEncryptor(tc.encryptionKey, rand.Reader)
+			if err == nil {
+				var cipherpackage []byte
+				cipherpackage, err = encryptor.Crypt(tc.plaintext, tc.additionalData)
+				if err == nil {
+					var decryptor libcipher.Decryptor
+					decryptor, err = libcipher.NewXChaChaDecryptor(tc.encryptionKey)
+					if err != nil {
+						t.Fatal(err)
+					}
+					decrypted, ad, err := decryptor.Crypt(cipherpackage)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(decrypted, tc.plaintext) || !bytes.Equal(ad, tc.additionalData) {
+						t.Fatalf("Decrypted data doesn't match original plaintext: %s : %s", decrypted, tc.plaintext)
+					}
+				}
+			}
+			if fmt.Sprint(err) != fmt.Sprint(tc.expectedError) {
+				t.Fatalf("expected %v got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestXChaCha_Tampering(t *testing.T) {
+	key := []byte("mysecretencryptionkey12345671234")
+	encryptor, err := libcipher.NewXChaChaEncryptor(key, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor, err := libcipher.NewXChaChaDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherpackage, err := encryptor.Crypt([]byte("message"), []byte("ad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cipherpackage {
+		tampered := append([]byte{}, cipherpackage...)
+		tampered[i] ^= 1
+		if _, _, err := decryptor.Crypt(tampered); err == nil {
+			t.Fatalf("expected an error decrypting a package with byte %d flipped", i)
+		}
+	}
+	gcm, err := libcipher.NewGCMDecryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := gcm.Crypt(cipherpackage); err == nil {
+		t.Fatal("expected an error decrypting a XChaCha20-Poly1305 package with AES-GCM")
+	}
+}
@@ -0,0 +1,117 @@
+package libstore
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/u8717/crypt/libcipher"
+)
+
+// keySealingOps implements the Ops interface, it hides the keys of the underlying Ops behind deterministic encryption.
+type keySealingOps struct {
+	storeOps  Ops
+	encryptor libcipher.Encryptor
+	decryptor libcipher.Decryptor
+}
+
+// NewKeySealingOps wraps ops so every key is stored encrypted with deterministic AES-SIV.
+// The same key always maps to the same stored name, entries can still be looked up by key.
+// Stored names are base64url encoded, a key of n bytes takes about 42+4n/3 characters.
+//
+// Use a key dedicated to sealing keys, e.g. derived with libcipher.DeriveKey.
+// Equal keys in different stores sealed with the same key are recognizable.
+func NewKeySealingOps(ops Ops, keySealingKey []byte) (Ops, error) {
+	encryptor, err := libcipher.NewDeterministicEncryptor(keySealingKey)
+	if err != nil {
+		return nil, err
+	}
+	decryptor, err := libcipher.NewDeterministicDecryptor(keySealingKey)
+	if err != nil {
+		return nil, err
+	}
+	return keySealingOps{storeOps: ops, encryptor: encryptor, decryptor: decryptor}, nil
+}
+
+// seal encrypts a key to the name used by the underlying Ops.
+func (s keySealingOps) seal(key string) (string, error) {
+	sealed, err := s.encryptor.Crypt([]byte(key), nil)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", KeyError("sealing key"), err)
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a name of the underlying Ops to the key.
+func (s keySealingOps) open(name string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", KeyError(fmt.Sprintf("decoding sealed key %s", name)), err)
+	}
+	key, _, err := s.decryptor.Crypt(sealed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", KeyError(fmt.Sprintf("opening sealed key %s", name)), err)
+	}
+	return string(key), nil
+}
+
+// Create implements libstore.Ops.
+func (s keySealingOps) Create(key string) error {
+	name, err := s.seal(key)
+	if err != nil {
+		return err
+	}
+	return s.storeOps.Create(name)
+}
+
+// ReadWhole implements libstore.Ops.
+func (s keySealingOps) ReadWhole(key string) ([][]byte, error) {
+	name, err := s.seal(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.storeOps.ReadWhole(name)
+}
+
+// ReadLast implements libstore.Ops.
+func (s keySealingOps) ReadLast(key string) ([]byte, error) {
+	name, err := s.seal(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.storeOps.ReadLast(name)
+}
+
+// AppendTo implements libstore.Ops.
+func (s keySealingOps) AppendTo(key string, entry []byte) error {
+	name, err := s.seal(key)
+	if err != nil {
+		return err
+	}
+	return s.storeOps.AppendTo(name, entry)
+}
+
+// Delete implements libstore.Ops.
+func (s keySealingOps) Delete(key string) error {
+	name, err := s.seal(key)
+	if err != nil {
+		return err
+	}
+	return s.storeOps.Delete(name)
+}
+
+// List implements libstore.Ops.
+// It returns an error if a name of the underlying Ops is not a key sealed with this key.
+func (s keySealingOps) List() ([]string, error) {
+	names, err := s.storeOps.List()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, len(names))
+	for i, name := range names {
+		res[i], err = s.open(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
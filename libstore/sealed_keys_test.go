@@ -0,0 +1,64 @@
+package libstore_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u8717/crypt/libstore"
+)
+
+func TestKeySealingOps(t *testing.T) {
+	location := t.TempDir()
+	fileOps, err := libstore.NewFileOps(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, err := libstore.NewKeySealingOps(fileOps, bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ops.Create("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ops.AppendTo("user@example.com", []byte("entry")); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := ops.ReadLast("user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entry) != "entry" {
+		t.Fatalf("unexpected entry %q", entry)
+	}
+	if err := ops.Create("user@example.com"); err == nil {
+		t.Fatal("expected an error creating an existing key")
+	}
+
+	// The plain key never reaches the underlying store.
+	if _, err := os.Stat(filepath.Join(location, "user@example.com")); !os.IsNotExist(err) {
+		t.Fatal("key was stored in plain")
+	}
+	keys, err := ops.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "user@example.com" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	// A different key can't open the names.
+	other, err := libstore.NewKeySealingOps(fileOps, bytes.Repeat([]byte{0x24}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.List(); err == nil {
+		t.Fatal("expected an error listing with a different key")
+	}
+
+	if err := ops.Delete("user@example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -10,6 +10,9 @@ import (
 
 const tsFormat = "2006-01-02 15:04:05.999999999 -0700 MST"
 
+// kdfContext binds the keys derived by NewManagerFromMasterKey to the store.
+const kdfContext = "libstore/store_cryptor"
+
 type CryptStore struct {
 	storeOps  Ops
 	encryptor libcipher.Encryptor
@@ -28,6 +31,44 @@ func NewManager(ops Ops, encyptionKey []byte, integrityKey []byte, calculateMAC
 	return CryptStore{storeOps: ops, encryptor: encryptor, decryptor: decryptor}, nil
 }
 
+// NewManagerWithAlgorithm creates a CryptStore using the libcipher algorithm registered under name, e.g. "aes-256-gcm".
+// The keys have to match the key sizes of the algorithm, libcipher.DeriveAlgorithmKeys derives them from a master key.
+func NewManagerWithAlgorithm(ops Ops, algorithm string, keys ...[]byte) (Ops, error) {
+	encryptor, err := libcipher.NewEncryptor(algorithm, keys...)
+	if err != nil {
+		return nil, err
+	}
+	decryptor, err := libcipher.NewDecryptor(algorithm, keys...)
+	if err != nil {
+		return nil, err
+	}
+	return CryptStore{storeOps: ops, encryptor: encryptor, decryptor: decryptor}, nil
+}
+
+// NewManagerFromMasterKey creates a CryptStore with encryption & integrity keys derived from a single master key via HKDF.
+func NewManagerFromMasterKey(ops Ops, masterKey []byte, calculateMAC func() hash.Hash) (Ops, error) {
+	encyptionKey, integrityKey, err := libcipher.DeriveCBCHMACKeys(masterKey, nil, kdfContext, calculateMAC)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewManager(ops, encyptionKey, integrityKey, calculateMAC)
+}
+
+// NewManagerFromPassword creates a CryptStore with keys derived from a password.
+// The salt & cost parameters are stored with every entry, the password is all that is needed to read the store.
+func NewManagerFromPassword(ops Ops, password []byte, params libcipher.PasswordParams) (Ops, error) {
+	encryptor, err := libcipher.NewPasswordEncryptor(password, params, libcipher.AlgorithmCBCHMAC)
+	if err != nil {
+		return nil, err
+	}
+	decryptor, err := libcipher.NewPasswordDecryptor(password)
+	if err != nil {
+		return nil, err
+	}
+	return CryptStore{storeOps: ops, encryptor: encryptor, decryptor: decryptor}, nil
+}
+
 // AppendTo implements libstore.Ops.
 func (m CryptStore) AppendTo(key string, entry []byte) error {
 	ts := []byte(time.Now().UTC().Format(tsFormat))